@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"errors"
 )
 
+// ErrAesPadding is returned by AesCbcDecryptChecked when the PKCS#7 padding
+// of the decrypted data is invalid, e.g. for a wrong key.
+var ErrAesPadding = errors.New("cryptor: invalid pkcs7 padding")
+
 func pkcs7Padding(src []byte, blockSize int) []byte {
 	padding := blockSize - len(src)%blockSize
 	var padText []byte
@@ -19,6 +24,25 @@ func pkcs7Padding(src []byte, blockSize int) []byte {
 	return append(src, padText...)
 }
 
+// pkcs7UnPaddingChecked removes the padding after verifying every padding
+// byte.
+func pkcs7UnPaddingChecked(src []byte, blockSize int) ([]byte, error) {
+	length := len(src)
+	if length == 0 || length%blockSize != 0 {
+		return nil, ErrAesPadding
+	}
+	unPadding := int(src[length-1])
+	if unPadding < 1 || unPadding > blockSize {
+		return nil, ErrAesPadding
+	}
+	for _, b := range src[length-unPadding:] {
+		if int(b) != unPadding {
+			return nil, ErrAesPadding
+		}
+	}
+	return src[:length-unPadding], nil
+}
+
 func pkcs7UnPadding(src []byte) []byte {
 	length := len(src)
 	unPadding := int(src[length-1])
@@ -51,3 +75,27 @@ func AesCbcDecrypt(encrypted, key []byte, iv []byte) []byte {
 	decrypted = pkcs7UnPadding(decrypted)
 	return decrypted
 }
+
+// AesCbcDecryptChecked decrypt data with key use AES CBC algorithm like
+// AesCbcDecrypt, but returns an error for an invalid key, IV, ciphertext
+// length or padding instead of panicking or returning garbage.
+// len(key) should be 16, 24 or 32, len(iv) 16
+func AesCbcDecryptChecked(encrypted, key []byte, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.New("cryptor: iv length must equal the block size")
+	}
+	if len(encrypted) == 0 || len(encrypted)%block.BlockSize() != 0 {
+		return nil, errors.New("cryptor: ciphertext is not a multiple of the block size")
+	}
+
+	mode := cipher.NewCBCDecrypter(block, iv)
+
+	decrypted := make([]byte, len(encrypted))
+	mode.CryptBlocks(decrypted, encrypted)
+
+	return pkcs7UnPaddingChecked(decrypted, block.BlockSize())
+}
@@ -1,6 +1,7 @@
 package cryptor
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -11,3 +12,28 @@ func Test_AesCbc(t *testing.T) {
 	decrypt := AesCbcDecrypt(encrypt, key, key)
 	t.Log(content == string(decrypt))
 }
+
+func Test_AesCbcDecryptChecked(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	for _, content := range []string{"", "id=1", "0123456789abcdef"} {
+		decrypt, err := AesCbcDecryptChecked(AesCbcEncrypt([]byte(content), key, iv), key, iv)
+		if err != nil || string(decrypt) != content {
+			t.Fatalf("%q: got %q %v", content, decrypt, err)
+		}
+	}
+
+	encrypt := AesCbcEncrypt([]byte("id=1"), key, iv)
+	if _, err := AesCbcDecryptChecked(encrypt, []byte("ffffffffffffffff"), iv); !errors.Is(err, ErrAesPadding) {
+		t.Fatalf("wrong key: expected ErrAesPadding, got %v", err)
+	}
+	if _, err := AesCbcDecryptChecked(encrypt[:10], key, iv); err == nil {
+		t.Fatal("truncated ciphertext accepted")
+	}
+	if _, err := AesCbcDecryptChecked(encrypt, key[:5], iv); err == nil {
+		t.Fatal("invalid key accepted")
+	}
+	if _, err := AesCbcDecryptChecked(encrypt, key, iv[:8]); err == nil {
+		t.Fatal("invalid iv accepted")
+	}
+}
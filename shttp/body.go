@@ -0,0 +1,135 @@
+package shttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// BodyReader streams the request body from reader instead of buffering it.
+// The length is taken from readers with a Len or Stat method, otherwise the
+// body is sent with chunked transfer encoding, see ContentLength.
+//
+// The body can be sent again on redirects when reader is a *bytes.Reader,
+// *bytes.Buffer, *strings.Reader or an io.Seeker, which is rewound to its
+// current offset. A seeker is not closed, the caller closes it after the
+// request; any other io.ReadCloser is closed once the body was sent. Use
+// BodyFunc for other replayable sources.
+func (r *Request) BodyReader(reader io.Reader) {
+	if r.body != nil || r.bodyFunc != nil || reader == nil {
+		return
+	}
+	r.bodyLength = -1
+	switch v := reader.(type) {
+	case *bytes.Buffer:
+		buf := v.Bytes()
+		r.bodyLength = int64(len(buf))
+		r.bodyFunc = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf)), nil
+		}
+		return
+	case *bytes.Reader:
+		snapshot := *v
+		r.bodyLength = int64(v.Len())
+		r.bodyFunc = func() (io.ReadCloser, error) {
+			rd := snapshot
+			return io.NopCloser(&rd), nil
+		}
+		return
+	case *strings.Reader:
+		snapshot := *v
+		r.bodyLength = int64(v.Len())
+		r.bodyFunc = func() (io.ReadCloser, error) {
+			rd := snapshot
+			return io.NopCloser(&rd), nil
+		}
+		return
+	}
+
+	if l, ok := reader.(interface{ Len() int }); ok {
+		r.bodyLength = int64(l.Len())
+	}
+	if seeker, ok := reader.(io.Seeker); ok {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			if f, ok := reader.(interface{ Stat() (os.FileInfo, error) }); ok && r.bodyLength < 0 {
+				if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+					r.bodyLength = fi.Size() - offset
+				}
+			}
+			r.bodyFunc = func() (io.ReadCloser, error) {
+				if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return io.NopCloser(reader), nil
+			}
+			return
+		}
+	}
+
+	// a one-shot reader, it can not be replayed
+	rc, ok := reader.(io.ReadCloser)
+	if !ok {
+		rc = io.NopCloser(reader)
+	}
+	used := false
+	r.bodyFunc = func() (io.ReadCloser, error) {
+		if used {
+			return nil, errors.New("request body can not be replayed")
+		}
+		used = true
+		return rc, nil
+	}
+	r.bodyOnce = true
+}
+
+// BodyFunc streams the request body returned by fn, which is called again
+// for every replay on redirects, so it must return a fresh reader from the
+// start of the body.
+func (r *Request) BodyFunc(fn func() (io.ReadCloser, error)) {
+	if r.body != nil || r.bodyFunc != nil || fn == nil {
+		return
+	}
+	r.bodyFunc = fn
+	r.bodyLength = -1
+}
+
+// ContentLength sets the length of a streamed body, a negative length sends
+// it with chunked transfer encoding. It must match the bytes of the body.
+func (r *Request) ContentLength(n int64) {
+	if n < 0 {
+		n = -1
+	}
+	r.contentLength = n
+	r.contentLengthSet = true
+}
+
+// buildStreamBody sets the streamed body on the request.
+func (r *Request) buildStreamBody() error {
+	if r.bodyFunc == nil {
+		return nil
+	}
+	body, err := r.bodyFunc()
+	if err != nil {
+		return err
+	}
+	r.req.Body = body
+	r.req.ContentLength = r.bodyLength
+	if r.contentLengthSet {
+		r.req.ContentLength = r.contentLength
+	}
+	if r.req.ContentLength == 0 {
+		// zero means unknown length for a non-nil body
+		body.Close()
+		r.req.Body = http.NoBody
+	}
+	if !r.bodyOnce {
+		r.req.GetBody = r.bodyFunc
+	} else {
+		r.req.GetBody = nil
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package shttp_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Request_BodyReader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/echo", http.StatusTemporaryRedirect)
+			return
+		}
+		bs, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d %v %s", r.ContentLength, r.TransferEncoding, bs)
+	}))
+	defer srv.Close()
+	client := shttp.New()
+
+	// a reader of unknown length is sent chunked
+	body, err := client.PostToString(srv.URL+"/echo", func(c *shttp.Client, req *shttp.Request) {
+		req.BodyReader(io.MultiReader(strings.NewReader("chunk1"), strings.NewReader("chunk2")))
+	})
+	if err != nil || body != "-1 [chunked] chunk1chunk2" {
+		t.Fatalf("unexpected body %q %v", body, err)
+	}
+
+	// a file is replayed from its offset on a 307 redirect
+	path := filepath.Join(t.TempDir(), "body")
+	os.WriteFile(path, []byte("skip:file body"), 0o600)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Seek(5, io.SeekStart)
+	body, err = client.PostToString(srv.URL+"/redirect", func(c *shttp.Client, req *shttp.Request) {
+		req.BodyReader(f)
+	})
+	if err != nil || body != "9 [] file body" {
+		t.Fatalf("unexpected body %q %v", body, err)
+	}
+
+	resp, err := client.Request(srv.URL+"/echo", shttp.PUT, strings.NewReader("reader"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.String(); body != "6 [] reader" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
@@ -0,0 +1,119 @@
+package shttp
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+var (
+	charsetBOMs = []struct {
+		bom   []byte
+		label string
+	}{
+		{[]byte{0xEF, 0xBB, 0xBF}, "utf-8"},
+		{[]byte{0xFE, 0xFF}, "utf-16be"},
+		{[]byte{0xFF, 0xFE}, "utf-16le"},
+	}
+	metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([\w.:-]+)`)
+)
+
+// WithResponseCharset decodes the responses with the charset label instead
+// of the detected charset, whatever they declare. The labels of the WHATWG
+// encoding standard are supported, e.g. "gbk", "gb18030", "big5" or
+// "shift_jis".
+func WithResponseCharset(label string) Option {
+	return func(c *Client) {
+		if e, _ := charset.Lookup(label); e == nil {
+			c.setErr(errors.New("unknown charset " + label))
+			return
+		}
+		c.charset = label
+	}
+}
+
+// ResponseCharset decodes the response of this request with the charset
+// label, see WithResponseCharset.
+func (r *Request) ResponseCharset(label string) {
+	r.charset = label
+}
+
+// Text returns the body decoded to UTF-8, see Charset.
+func (r *Response) Text() (string, error) {
+	bs, err := r.Bytes()
+	if err != nil {
+		return "", err
+	}
+	e, _, bom, err := r.detectCharset(bs)
+	if err != nil {
+		return "", err
+	}
+	bs = bs[bom:]
+	if e == nil {
+		return string(bs), nil
+	}
+	text, err := e.NewDecoder().Bytes(bs)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// Charset returns the name of the body charset: the forced charset, else
+// the byte order mark, the charset of the Content-Type header or the
+// <meta> charset of an HTML body, "utf-8" when none is declared.
+func (r *Response) Charset() (string, error) {
+	bs, err := r.Bytes()
+	if err != nil {
+		return "", err
+	}
+	_, name, _, err := r.detectCharset(bs)
+	return name, err
+}
+
+// detectCharset returns the encoding of the body, nil for UTF-8, its name
+// and the length of the byte order mark.
+func (r *Response) detectCharset(bs []byte) (encoding.Encoding, string, int, error) {
+	label, bom := r.charset, 0
+	if label == "" {
+		for _, b := range charsetBOMs {
+			if bytes.HasPrefix(bs, b.bom) {
+				label, bom = b.label, len(b.bom)
+				break
+			}
+		}
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.resp.Header.Get(httpHeaderContentType))
+	if label == "" {
+		label = params["charset"]
+	}
+	if label == "" && (mediaType == "" || strings.Contains(mediaType, "html")) {
+		head := bs
+		if len(head) > 1024 {
+			head = head[:1024]
+		}
+		if m := metaCharset.FindSubmatch(head); m != nil {
+			label = string(m[1])
+		}
+	}
+	if label == "" {
+		return nil, "utf-8", bom, nil
+	}
+	e, name := charset.Lookup(label)
+	if e == nil {
+		if r.charset != "" {
+			return nil, "", 0, errors.New("unknown charset " + label)
+		}
+		// an unknown declared charset is kept as is
+		return nil, "utf-8", bom, nil
+	}
+	if name == "utf-8" {
+		e = nil
+	}
+	return e, name, bom, nil
+}
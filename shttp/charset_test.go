@@ -0,0 +1,62 @@
+package shttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func Test_Response_Text(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("你好")
+	gb18030, _ := simplifiedchinese.GB18030.NewEncoder().String("<html><head><meta charset=\"gb18030\"></head>你好</html>")
+	big5, _ := traditionalchinese.Big5.NewEncoder().String("你好")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Content-Type", "text/plain; charset=GBK")
+			w.Write([]byte(gbk))
+		case "/meta":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(gb18030))
+		case "/bom":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("\xEF\xBB\xBF你好"))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(big5))
+		}
+	}))
+	defer srv.Close()
+
+	client := shttp.New()
+	for path, want := range map[string]string{
+		"/header": "你好",
+		"/meta":   "<html><head><meta charset=\"gb18030\"></head>你好</html>",
+		"/bom":    "你好",
+	} {
+		body, err := client.GetToString(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != want {
+			t.Fatalf("%s: unexpected text %q", path, body)
+		}
+	}
+
+	body, err := client.GetToString(srv.URL+"/big5", func(c *shttp.Client, req *shttp.Request) {
+		req.ResponseCharset("big5")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "你好" {
+		t.Fatalf("forced charset ignored, got %q", body)
+	}
+	if _, err := shttp.New(shttp.WithResponseCharset("klingon")).Get(srv.URL); err == nil {
+		t.Fatal("unknown charset accepted")
+	}
+}
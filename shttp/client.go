@@ -1,6 +1,7 @@
 package shttp
 
 import (
+	"container/list"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -9,7 +10,10 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"time"
+	"sync"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/http2"
 )
 
 type (
@@ -24,105 +28,233 @@ const VERSION = "0.1.0"
 
 var (
 	defaultClientAgent = fmt.Sprintf(`sHttp %s`, VERSION)
-
-	connectTimeout   = 5 * time.Second
-	readWriteTimeout = 5 * time.Second
 )
 
+// ErrClientBuilt is returned by the deprecated Client setters called after
+// New, use Clone with the option instead.
+var ErrClientBuilt = errors.New("shttp: client settings are fixed after New, use Clone")
+
+// Client sends requests and is safe for concurrent use. Configure it with
+// the options of New, derive a client with other settings with Clone, or
+// override the proxy, TLS config or timeouts of a single request on the
+// Request. The settings are fixed once New returns: the deprecated Client
+// setters of the options only apply while the options run, afterwards they
+// do nothing and those returning an error return ErrClientBuilt.
 type Client struct {
 	c *http.Client
 
+	// opts are the options of New and the middlewares of Use, replayed by
+	// Clone
+	opts  []Option
+	built bool
+
 	middlewares []Middleware
-}
 
-var DefaultClient = &Client{
-	c:           http.DefaultClient,
-	middlewares: make([]Middleware, 0),
+	// h2 is the HTTP/2 transport configured on h2Base by HTTP2
+	h2     *http2.Transport
+	h2Base *http.Transport
+
+	timeouts    Timeouts
+	dial        DialFunc
+	localAddr   net.Addr
+	unixSockets map[string]string
+	dns         *resolver
+	conns       *connStats
+	redirect    RedirectPolicy
+	hedge       *HedgeConfig
+	endpoints   map[string]*EndpointGroup
+	charset     string
+	bodyLimits  BodyLimits
+
+	uploadLimit   *RateLimiter
+	downloadLimit *RateLimiter
+
+	baseURL *url.URL
+	headers url.Values
+	queries url.Values
+	cookies []*http.Cookie
+
+	proxy *url.URL
+	// envProxy is the proxy function of the environment variables read by
+	// ProxyFromEnvironment
+	envProxy       func(*url.URL) (*url.URL, error)
+	transportProxy func(*http.Request) (*url.URL, error)
+
+	// tlsClients are the clients of per-request TLS configs, tlsLRU orders
+	// them by use
+	tlsMu      sync.Mutex
+	tlsClients map[*tls.Config]*list.Element
+	tlsLRU     *list.List
+
+	// err is the first error of an Option, returned by every request
+	err error
 }
 
+// DefaultClient is used by the package functions Get and Post. It has its
+// own transport, which uses the proxy of the environment.
+var DefaultClient = New(WithProxyFromEnvironment())
+
 func New(opts ...Option) *Client {
 	options := &Client{
 		c: &http.Client{
-			Transport:     defaultTransport(),
-			CheckRedirect: defaultCheckRedirect(),
-			Jar:           nil,
-			Timeout:       connectTimeout,
+			Jar: nil,
 		},
 		middlewares: make([]Middleware, 0),
+		timeouts:    DefaultTimeouts,
+		conns:       newConnStats(),
+		redirect:    DefaultRedirectPolicy,
 	}
+	options.c.CheckRedirect = options.checkRedirect
+	options.c.Transport = defaultTransport(options.dialContext, options.proxyFunc)
+	options.opts = append([]Option(nil), opts...)
 	for _, o := range opts {
 		o(options)
 	}
+	if rt := findProxyPool(options.c.Transport); rt != nil {
+		rt.pool.useTransport(rt.next)
+	}
+	if len(options.endpoints) > 0 {
+		next := options.c.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		options.c.Transport = &endpointRoundTripper{next: next, groups: options.endpoints}
+		for _, g := range options.endpoints {
+			g.useTransport(next)
+		}
+	}
+	if options.hedge != nil {
+		options.c.Transport = newHedgeRoundTripper(options.c.Transport, *options.hedge)
+	}
+	options.built = true
 	return options
 }
 
+// Clone returns a new client configured by the options of c, its
+// middlewares and opts. The clone has its own transport and connection
+// pool, a ProxyPool is shared.
+func (c *Client) Clone(opts ...Option) *Client {
+	all := make([]Option, 0, len(c.opts)+len(opts))
+	all = append(all, c.opts...)
+	return New(append(all, opts...)...)
+}
+
 func WithMiddleware(middleware Middleware) Option {
 	return func(opts *Client) {
 		opts.Use(middleware)
 	}
 }
 
+// Use adds a middleware to c and returns c. It must not be called while c
+// sends requests, prefer WithMiddleware.
 func (c *Client) Use(middleware Middleware) *Client {
 	if c.middlewares == nil {
 		c.middlewares = make([]Middleware, 0)
 	}
 	c.middlewares = append(c.middlewares, middleware)
+	if c.built {
+		c.opts = append(c.opts, WithMiddleware(middleware))
+	}
 	return c
 }
 
-func (c *Client) ProxyUrl(proxy *url.URL) {
-	proxyFunc := func(r *http.Request) (*url.URL, error) {
-		return proxy, nil
-	}
-	if t, ok := c.c.Transport.(*http.Transport); ok {
-		t.Proxy = proxyFunc
-	} else {
-		c.c.Transport = &http.Transport{
-			Proxy: proxyFunc,
+// transportWrapper is implemented by round trippers that delegate to an
+// underlying transport, so the client settings can still reach it.
+type transportWrapper interface {
+	unwrap() http.RoundTripper
+}
+
+// closeIdleConnections closes the idle connections of rt or of the first
+// transport below its wrappers that can.
+func closeIdleConnections(rt http.RoundTripper) {
+	for rt != nil {
+		if ci, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			ci.CloseIdleConnections()
+			return
+		}
+		w, ok := rt.(transportWrapper)
+		if !ok {
+			return
 		}
+		rt = w.unwrap()
 	}
 }
 
-func (c *Client) Transport(t *http.Transport) {
+// transport returns the *http.Transport the client settings apply to. A
+// client without one gets a copy of http.DefaultTransport, which keeps the
+// standard library defaults including the environment proxy settings.
+func (c *Client) transport() *http.Transport {
+	if t := c.baseTransport(); t != nil {
+		return t
+	}
+	var t *http.Transport
+	if dt, ok := http.DefaultTransport.(*http.Transport); ok {
+		t = dt.Clone()
+	} else {
+		t = &http.Transport{}
+	}
+	t.Proxy = c.proxyFunc
+	c.envProxy = httpproxy.FromEnvironment().ProxyFunc()
 	c.c.Transport = t
+	return t
 }
 
-func (c *Client) TLSClientConfig(tls *tls.Config) {
-	if t, ok := c.c.Transport.(*http.Transport); ok {
-		t.TLSClientConfig = tls
-	} else {
-		c.c.Transport = &http.Transport{
-			TLSClientConfig: tls,
+// baseTransport returns the *http.Transport below any wrappers, nil if the
+// client uses another kind of round tripper.
+func (c *Client) baseTransport() *http.Transport {
+	rt := c.c.Transport
+	for {
+		switch t := rt.(type) {
+		case *http.Transport:
+			return t
+		case transportWrapper:
+			rt = t.unwrap()
+		default:
+			return nil
 		}
 	}
 }
 
-func (c *Client) Timeout(connectTimeout time.Duration, readWriteTimeout time.Duration) {
-	dialCtx := func(ctx context.Context, network, addr string) (net.Conn, error) {
-		conn, err := net.DialTimeout(network, addr, connectTimeout)
-		if err != nil {
-			return nil, err
-		}
-		err = conn.SetDeadline(time.Now().Add(readWriteTimeout))
-		return conn, err
+// WithTransport uses a copy of t as the client transport, see Client.Transport.
+func WithTransport(t *http.Transport) Option {
+	return func(c *Client) {
+		c.Transport(t)
 	}
-	if t, ok := c.c.Transport.(*http.Transport); ok {
-		t.DialContext = dialCtx
-	} else {
-		c.c.Transport = &http.Transport{
-			DialContext: dialCtx,
-		}
+}
+
+// Transport replaces the client transport with a copy of t. The Proxy
+// function of t is used when the request and the client have no proxy, see
+// ProxyUrl.
+//
+// Deprecated: use WithTransport, see Client.
+func (c *Client) Transport(t *http.Transport) {
+	if c.built {
+		return
 	}
+	c.transportProxy = nil
+	if t == nil {
+		c.c.Transport = nil
+		return
+	}
+	t = t.Clone()
+	c.transportProxy = t.Proxy
+	t.Proxy = c.proxyFunc
+	c.c.Transport = t
 }
 
 func (c *Client) Request(url string, method Method, body io.Reader, handlers ...RequestHandler) (*Response, error) {
 	if c == nil {
 		return nil, errors.New("client is nil")
 	}
-	httpReq, err := http.NewRequest(method.String(), url, body)
+	httpReq, err := http.NewRequest(method.String(), c.resolveURL(url), nil)
 	if err != nil {
 		return nil, err
 	}
+	if body != nil {
+		handlers = append([]RequestHandler{func(c *Client, req *Request) {
+			req.BodyReader(body)
+		}}, handlers...)
+	}
 	return c.Do(c.handlerRequest(httpReq, handlers...))
 }
 
@@ -168,6 +300,7 @@ func (c *Client) PostToString(url string, handlers ...RequestHandler) (string, e
 
 func (c *Client) handlerRequest(httpReq *http.Request, handlers ...RequestHandler) *Request {
 	req := NewRequest(httpReq)
+	c.applyDefaults(req)
 	if handlers != nil && len(handlers) > 0 {
 		for _, handler := range handlers {
 			handler(c, req)
@@ -180,6 +313,20 @@ func (c *Client) handlerRequest(httpReq *http.Request, handlers ...RequestHandle
 }
 
 func (c *Client) Do(req *Request) (*Response, error) {
+	return c.do(req, (*Client).httpClient, false)
+}
+
+func (c *Client) httpClient() *http.Client {
+	return c.c
+}
+
+// do sends the request with the http client returned by client, called on
+// the client of the request TLS config if it has one. A stream response body
+// is not bound by the idle read and overall timeouts of the client.
+func (c *Client) do(req *Request, client func(*Client) *http.Client, stream bool) (*Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
 	var err error
 	if len(c.middlewares) > 0 {
 		for _, m := range c.middlewares {
@@ -193,14 +340,56 @@ func (c *Client) Do(req *Request) (*Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	httpResp, err := c.c.Do(httpReq)
+	if err := c.checkProtocol(httpReq); err != nil {
+		return nil, err
+	}
+	throttleRequest(httpReq, limiters(c.uploadLimit, req.uploadLimit))
+	hc := client(c)
+	if req.tlsConfig != nil {
+		hc = client(c.tlsClient(req.tlsConfig))
+	}
+	history := &redirectHistory{}
+	httpReq = httpReq.WithContext(context.WithValue(httpReq.Context(), redirectHistoryKey{}, history))
+	var trace *connTrace
+	if c.conns != nil {
+		httpReq, trace = c.conns.withTrace(httpReq)
+	}
+	limits := c.requestBodyLimits(req)
+	gzipped := !stream && c.acceptGzip(httpReq, limits)
+	httpResp, err := send(hc, httpReq, c.requestTimeouts(req, stream))
+	if trace != nil {
+		if err != nil {
+			trace.release()
+		} else if httpResp.StatusCode != http.StatusSwitchingProtocols {
+			httpResp.Body = &releaseBody{ReadCloser: httpResp.Body, release: trace.release}
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+	if !stream && httpResp.StatusCode != http.StatusSwitchingProtocols {
+		limitResponse(httpResp, limits)
+		if gzipped {
+			decompressResponse(httpResp, limits)
+		}
+	}
+	if ls := limiters(c.downloadLimit, req.downloadLimit); len(ls) > 0 && httpResp.StatusCode != http.StatusSwitchingProtocols {
+		ctx := httpReq.Context()
+		if httpResp.Request != nil {
+			ctx = httpResp.Request.Context()
+		}
+		httpResp.Body = &throttledBody{rc: httpResp.Body, ctx: ctx, limiters: ls}
+	}
 	resp := NewResponse(httpResp)
 	if resp == nil {
 		return nil, errors.New("response is nil")
 	}
+	resp.redirects = history.hops
+	resp.limits = limits
+	resp.charset = c.charset
+	if req.charset != "" {
+		resp.charset = req.charset
+	}
 	if len(c.middlewares) > 0 {
 		for _, m := range c.middlewares {
 			err = m(c, req, resp)
@@ -212,31 +401,19 @@ func (c *Client) Do(req *Request) (*Response, error) {
 	return resp, nil
 }
 
-func defaultTransport() *http.Transport {
+func defaultTransport(dial DialFunc, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
 	return &http.Transport{
-		// No validation for https certification of the server in default.
+		Proxy: proxy,
 		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
+			MinVersion: tls.VersionTLS12,
 		},
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			conn, err := net.DialTimeout(network, addr, connectTimeout)
-			if err != nil {
-				return nil, err
-			}
-			err = conn.SetDeadline(time.Now().Add(readWriteTimeout))
-			return conn, err
-		},
-		MaxIdleConnsPerHost: 100,
-		DisableKeepAlives:   true,
-	}
-}
-
-func defaultCheckRedirect() func(req *http.Request, via []*http.Request) error {
-	return func(req *http.Request, via []*http.Request) error {
-		if len(via) >= 5 {
-			return errors.New("stopped after 5 redirects")
-		}
-		return nil
+		DialContext:         dial,
+		TLSHandshakeTimeout: DefaultTimeouts.TLSHandshake,
+		MaxIdleConns:        DefaultPoolConfig.MaxIdleConns,
+		MaxIdleConnsPerHost: DefaultPoolConfig.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     DefaultPoolConfig.MaxConnsPerHost,
+		IdleConnTimeout:     DefaultPoolConfig.IdleConnTimeout,
+		DisableKeepAlives:   DefaultPoolConfig.DisableKeepAlives,
 	}
 }
 
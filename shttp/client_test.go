@@ -0,0 +1,179 @@
+package shttp_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_Clone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Env")))
+	}))
+	defer srv.Close()
+
+	client := shttp.New(shttp.WithHeader("X-Env", "prod"))
+	staging := client.Clone(shttp.WithHeader("X-Env", "staging"))
+	for c, want := range map[*shttp.Client]string{client: "prod", staging: "staging"} {
+		body, err := c.GetToString(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != want {
+			t.Fatalf("expected %q, got %q", want, body)
+		}
+	}
+
+	// the settings are fixed after New
+	client.Header("X-Env", "dev")
+	if body, err := client.GetToString(srv.URL); err != nil || body != "prod" {
+		t.Fatalf("expected %q, got %q %v", "prod", body, err)
+	}
+	if err := client.RootCA(nil); !errors.Is(err, shttp.ErrClientBuilt) {
+		t.Fatalf("expected ErrClientBuilt, got %v", err)
+	}
+
+	// Use changes the client in place and its clones keep the middleware
+	used := 0
+	client.Use(func(c *shttp.Client, req *shttp.Request, resp *shttp.Response) error {
+		if resp == nil {
+			used++
+		}
+		return nil
+	})
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Clone().Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if used != 2 {
+		t.Fatalf("middleware ran %d times, expected 2", used)
+	}
+}
+
+func Test_Client_Concurrent(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+	defer origin.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxy"))
+	}))
+	defer proxy.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tls"))
+	}))
+	defer tlsSrv.Close()
+
+	p, _ := url.Parse(proxy.URL)
+	insecure := &tls.Config{InsecureSkipVerify: true}
+	client := shttp.New()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			body, err := client.GetToString(origin.URL, shttp.ProxyHandler(p))
+			if err == nil && body != "proxy" {
+				t.Errorf("proxy override ignored, got %q", body)
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			body, err := client.GetToString(origin.URL)
+			if err == nil && body != "origin" {
+				t.Errorf("proxy override leaked, got %q", body)
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := client.GetToString(tlsSrv.URL, func(c *shttp.Client, req *shttp.Request) {
+				req.TLSConfig(insecure)
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := client.Get(tlsSrv.URL); err == nil {
+		t.Fatal("request TLS config leaked into the client")
+	}
+}
+
+func Test_Client_TLSConfigEviction(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tls"))
+	}))
+	defer srv.Close()
+
+	client := shttp.New()
+	for i := 0; i < 40; i++ {
+		// a fresh config per request gets a pool per request
+		cfg := &tls.Config{InsecureSkipVerify: true}
+		if _, err := client.GetToBytes(srv.URL, func(c *shttp.Client, req *shttp.Request) {
+			req.TLSConfig(cfg)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host := strings.TrimPrefix(srv.URL, "https://")
+	if open := client.PoolStats()[host].Open; open > 16 {
+		t.Fatalf("%d connections open, the evicted pools were not closed", open)
+	}
+}
+
+func Test_Client_SettersAfterNew(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var calls int32
+	client := shttp.New(
+		shttp.WithHedging(shttp.HedgeConfig{Delay: time.Hour}),
+		shttp.WithMiddleware(func(c *shttp.Client, req *shttp.Request, resp *shttp.Response) error {
+			// a setter on the live client must not race with the requests
+			c.ProxyUrl(nil)
+			c.Timeouts(shttp.Timeouts{})
+			c.HTTP2(shttp.HTTP2Config{})
+			if resp == nil {
+				atomic.AddInt32(&calls, 1)
+			}
+			return nil
+		}),
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetToString(srv.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&calls) != 8 {
+		t.Fatalf("unexpected middleware calls %d", calls)
+	}
+}
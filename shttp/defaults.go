@@ -0,0 +1,198 @@
+package shttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// pathPlaceholder matches {name} and its escaped form %7Bname%7D in a path.
+var pathPlaceholder = regexp.MustCompile(`\{([^{}/]+)\}|%7[Bb]([^{}/]+?)%7[Dd]`)
+
+// WithBaseURL resolves relative request urls against rawUrl, see Client.BaseURL.
+func WithBaseURL(rawUrl string) Option {
+	return func(c *Client) {
+		c.setErr(c.BaseURL(rawUrl))
+	}
+}
+
+// WithHeader sets a header on every request, see Client.Header.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.Header(key, value)
+	}
+}
+
+// WithHeaderMap sets the headers on every request.
+func WithHeaderMap(h Header) Option {
+	return func(c *Client) {
+		for k, v := range h {
+			c.Header(k, v)
+		}
+	}
+}
+
+// WithQuery sets a query parameter on every request, see Client.Query.
+func WithQuery(key, value string) Option {
+	return func(c *Client) {
+		c.Query(key, value)
+	}
+}
+
+// WithCookie adds a cookie to every request, see Client.Cookie.
+func WithCookie(cookie *http.Cookie) Option {
+	return func(c *Client) {
+		c.Cookie(cookie)
+	}
+}
+
+// BaseURL resolves the request urls without a scheme against rawUrl. The
+// url is appended to the base path, so with the base
+// "https://api.local/v1" both "users" and "/users" become
+// "https://api.local/v1/users". An empty rawUrl removes the base.
+//
+// Deprecated: use WithBaseURL, see Client.
+func (c *Client) BaseURL(rawUrl string) error {
+	if c.built {
+		return ErrClientBuilt
+	}
+	if rawUrl == "" {
+		c.baseURL = nil
+		return nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	c.baseURL = u
+	return nil
+}
+
+// Header sets a header on every request before the handlers run.
+//
+// Deprecated: use WithHeader, see Client.
+func (c *Client) Header(key, value string) {
+	if c.built {
+		return
+	}
+	if c.headers == nil {
+		c.headers = url.Values{}
+	}
+	c.headers.Set(key, value)
+}
+
+// Query sets a query parameter on every request before the handlers run.
+//
+// Deprecated: use WithQuery, see Client.
+func (c *Client) Query(key, value string) {
+	if c.built {
+		return
+	}
+	if c.queries == nil {
+		c.queries = url.Values{}
+	}
+	c.queries.Set(key, value)
+}
+
+// Cookie adds a cookie to every request before the handlers run.
+//
+// Deprecated: use WithCookie, see Client.
+func (c *Client) Cookie(cookie *http.Cookie) {
+	if c.built {
+		return
+	}
+	c.cookies = append(c.cookies, cookie)
+}
+
+// resolveURL joins a url without a scheme and host to the base url.
+func (c *Client) resolveURL(rawUrl string) string {
+	if c.baseURL == nil {
+		return rawUrl
+	}
+	ref, err := url.Parse(rawUrl)
+	if err != nil || ref.IsAbs() || ref.Host != "" {
+		return rawUrl
+	}
+	base := *c.baseURL
+	if ref.Path != "" || ref.RawPath != "" {
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(ref.Path, "/")
+		base.RawPath = ""
+		if ref.RawPath != "" {
+			base.RawPath = strings.TrimSuffix(base.EscapedPath(), "/") + "/" + strings.TrimPrefix(ref.RawPath, "/")
+		}
+	}
+	if ref.RawQuery != "" {
+		if base.RawQuery != "" {
+			base.RawQuery += "&" + ref.RawQuery
+		} else {
+			base.RawQuery = ref.RawQuery
+		}
+	}
+	base.Fragment = ref.Fragment
+	return base.String()
+}
+
+// applyDefaults sets the client headers, query parameters and cookies.
+func (c *Client) applyDefaults(req *Request) {
+	for k, v := range c.headers {
+		req.headers[k] = append([]string(nil), v...)
+	}
+	for k, v := range c.queries {
+		req.queries[k] = append([]string(nil), v...)
+	}
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+}
+
+// PathParam replaces the placeholder {key} in the url path with the
+// escaped value, e.g. "/users/{id}". The request fails when a placeholder
+// turns a path segment into "." or "..".
+func (r *Request) PathParam(key, value string) {
+	if r.pathParams == nil {
+		r.pathParams = map[string]string{}
+	}
+	r.pathParams[key] = value
+}
+
+// PathParamMap replaces the placeholders of the url path, see PathParam.
+func (r *Request) PathParamMap(p PathParams) {
+	for k, v := range p {
+		r.PathParam(k, v)
+	}
+}
+
+func (r *Request) buildPath() error {
+	if len(r.pathParams) == 0 || r.req.URL == nil {
+		return nil
+	}
+	segments := strings.Split(r.req.URL.EscapedPath(), "/")
+	for i, seg := range segments {
+		// one pass over the template, a value is never scanned for
+		// placeholders again
+		replaced := pathPlaceholder.ReplaceAllStringFunc(seg, func(m string) string {
+			sub := pathPlaceholder.FindStringSubmatch(m)
+			if v, ok := r.pathParams[sub[1]+sub[2]]; ok {
+				return url.PathEscape(v)
+			}
+			return m
+		})
+		if replaced == seg {
+			continue
+		}
+		if unescaped, _ := url.PathUnescape(replaced); unescaped == "." || unescaped == ".." {
+			return fmt.Errorf("shttp: path parameters turn the segment %q into %q", seg, unescaped)
+		}
+		segments[i] = replaced
+	}
+	rawPath := strings.Join(segments, "/")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return err
+	}
+	r.req.URL.Path = path
+	r.req.URL.RawPath = rawPath
+	return nil
+}
@@ -0,0 +1,101 @@
+package shttp_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_Defaults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, _ := r.Cookie("session")
+		fmt.Fprintf(w, "%s %s %s %s %s", r.URL.EscapedPath(), r.URL.RawQuery,
+			r.Header.Get("X-Api-Key"), r.Header.Get("X-Trace"), cookie.Value)
+	}))
+	defer srv.Close()
+
+	client := shttp.New(
+		shttp.WithBaseURL(srv.URL+"/v1/"),
+		shttp.WithHeader("X-Api-Key", "key"),
+		shttp.WithHeader("X-Trace", "client"),
+		shttp.WithQuery("lang", "en"),
+		shttp.WithCookie(&http.Cookie{Name: "session", Value: "s1"}),
+	)
+	body, err := client.GetToString("/users/{id}/orders/{orderId}?expand=1", func(c *shttp.Client, req *shttp.Request) {
+		req.PathParam("id", "a/b c")
+		req.PathParam("orderId", "42")
+		req.Header("X-Trace", "request")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/v1/users/a%2Fb%20c/orders/42 expand=1&lang=en key request s1"; body != want {
+		t.Fatalf("got %q, want %q", body, want)
+	}
+}
+
+func Test_Client_PathParamDotSegments(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.EscapedPath(), " ", r.URL.RawQuery)
+	}))
+	defer srv.Close()
+
+	client := shttp.New(shttp.WithBaseURL(srv.URL + "/v1"))
+	for _, tc := range []struct {
+		path string
+		id   string
+	}{
+		{"/users/{id}/orders", ".."},
+		{"/users/{id}/orders", "."},
+		{"/users/{id}{id}", "."},
+	} {
+		_, err := client.Get(tc.path, func(c *shttp.Client, req *shttp.Request) {
+			req.PathParam("id", tc.id)
+		})
+		if err == nil {
+			t.Fatalf("%s with id %q sent", tc.path, tc.id)
+		}
+	}
+	body, err := client.GetToString("/files/{name}.txt", func(c *shttp.Client, req *shttp.Request) {
+		req.PathParam("name", "..")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/v1/files/...txt "; body != want {
+		t.Fatalf("got %q, want %q", body, want)
+	}
+
+	// a url in the query is not an absolute url
+	body, err = client.GetToString("/redirect?to=http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/v1/redirect to=http://example.com"; body != want {
+		t.Fatalf("got %q, want %q", body, want)
+	}
+}
+
+func Test_Client_PathParamValueWithPlaceholder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.EscapedPath())
+	}))
+	defer srv.Close()
+
+	client := shttp.New(shttp.WithBaseURL(srv.URL))
+	for i := 0; i < 20; i++ {
+		body, err := client.GetToString("/users/{name}/x/{id}", func(c *shttp.Client, req *shttp.Request) {
+			req.PathParam("name", "{id}")
+			req.PathParam("id", "7")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "/users/%7Bid%7D/x/7"; body != want {
+			t.Fatalf("got %q, want %q", body, want)
+		}
+	}
+}
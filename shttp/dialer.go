@@ -0,0 +1,150 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// DialFunc dials a connection, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// WithDialContext dials every connection with dial, see Client.DialContext.
+func WithDialContext(dial DialFunc) Option {
+	return func(c *Client) {
+		c.DialContext(dial)
+	}
+}
+
+// WithUnixSocket dials the unix socket at path for host, see Client.UnixSocket.
+func WithUnixSocket(host, path string) Option {
+	return func(c *Client) {
+		c.UnixSocket(host, path)
+	}
+}
+
+// WithLocalAddr binds outgoing connections to ip, see Client.LocalAddr.
+func WithLocalAddr(ip string) Option {
+	return func(c *Client) {
+		c.LocalAddr(ip)
+	}
+}
+
+// DialContext dials every connection with dial instead of net.Dialer. The
+// dial timeout still bounds the dial through the context.
+//
+// Deprecated: use WithDialContext, see Client.
+func (c *Client) DialContext(dial DialFunc) {
+	if c.built {
+		return
+	}
+	c.dial = dial
+	c.transport().DialContext = c.dialContext
+}
+
+// UnixSocket sends the requests for host to the unix socket at path, e.g.
+// c.UnixSocket("docker", "/var/run/docker.sock") for http://docker/info.
+// host matches the request host with or without the port.
+//
+// Deprecated: use WithUnixSocket, see Client.
+func (c *Client) UnixSocket(host, path string) {
+	if c.built {
+		return
+	}
+	if c.unixSockets == nil {
+		c.unixSockets = make(map[string]string)
+	}
+	c.unixSockets[host] = path
+	c.transport().DialContext = c.dialContext
+}
+
+// LocalAddr binds the TCP connections to the local source ip, an empty ip
+// removes the binding. An invalid ip fails the requests of the client.
+//
+// Deprecated: use WithLocalAddr, see Client.
+func (c *Client) LocalAddr(ip string) {
+	if c.built {
+		return
+	}
+	c.localAddr = nil
+	if ip != "" {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			c.setErr(errors.New("shttp: invalid local ip " + ip))
+			return
+		}
+		c.localAddr = &net.TCPAddr{IP: parsed}
+	}
+	c.transport().DialContext = c.dialContext
+}
+
+// dialConn dials a connection bounded by the dial timeout of the request,
+// or of the client. The addresses of the client resolver are tried in turn,
+// each with an even share of the time left so that an unreachable address
+// does not use up the timeout of the others.
+func (c *Client) dialConn(ctx context.Context, network, addr string) (net.Conn, error) {
+	timeout := c.timeouts.Dial
+	if d, ok := ctx.Value(dialTimeoutKey{}).(time.Duration); ok {
+		timeout = d
+	}
+	if path, ok := c.unixSocket(addr); ok {
+		network, addr = "unix", path
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if c.dns == nil || network == "unix" {
+		return c.dialAddr(ctx, network, addr)
+	}
+	addrs, err := c.dns.resolveAddr(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range addrs {
+		var conn net.Conn
+		if conn, err = c.dialShare(ctx, network, a, len(addrs)-i); err == nil || ctx.Err() != nil {
+			return conn, err
+		}
+	}
+	return nil, err
+}
+
+// dialShare dials addr with the time left of ctx split evenly between the
+// remaining addresses.
+func (c *Client) dialShare(ctx context.Context, network, addr string, remaining int) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok && remaining > 1 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+		defer cancel()
+	}
+	return c.dialAddr(ctx, network, addr)
+}
+
+func (c *Client) dialAddr(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.dial != nil {
+		return c.dial(ctx, network, addr)
+	}
+	var d net.Dialer
+	if network != "unix" {
+		d.LocalAddr = c.localAddr
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+func (c *Client) unixSocket(addr string) (string, bool) {
+	if len(c.unixSockets) == 0 {
+		return "", false
+	}
+	if path, ok := c.unixSockets[addr]; ok {
+		return path, true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	path, ok := c.unixSockets[host]
+	return path, ok
+}
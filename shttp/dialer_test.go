@@ -0,0 +1,95 @@
+package shttp_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shttp.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("unix " + r.Host))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	body, err := shttp.New(shttp.WithUnixSocket("daemon", path)).GetToString("http://daemon/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "unix daemon" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func Test_Client_LocalAddr(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		w.Write([]byte(host))
+	}))
+	defer srv.Close()
+
+	body, err := shttp.New(shttp.WithLocalAddr("127.0.0.1")).GetToString(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "127.0.0.1" {
+		t.Fatalf("unexpected remote address %q", body)
+	}
+	if _, err := shttp.New(shttp.WithLocalAddr("not-an-ip")).Get(srv.URL); err == nil {
+		t.Fatal("request sent with an invalid local ip")
+	}
+}
+
+func Test_Client_DialContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("dialed " + r.Host))
+	}))
+	defer srv.Close()
+
+	var dialed []string
+	client := shttp.New(shttp.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.Listener.Addr().String())
+	}))
+	body, err := client.GetToString("http://service.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "dialed service.test" || len(dialed) != 1 || dialed[0] != "service.test:80" {
+		t.Fatalf("unexpected body %q, dialed %v", body, dialed)
+	}
+
+	// the dial timeout bounds a custom dial through its context
+	client = shttp.New(
+		shttp.WithTimeouts(shttp.Timeouts{Dial: 50 * time.Millisecond}),
+		shttp.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+				return nil, errors.New("dial not bounded by the timeout")
+			}
+		}),
+	)
+	start := time.Now()
+	if _, err := client.Get("http://service.test/"); err == nil {
+		t.Fatal("hanging dial succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dial took %s", elapsed)
+	}
+}
@@ -0,0 +1,296 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// IPPreference selects the address family of the dialed addresses.
+type IPPreference int
+
+const (
+	// IPAny dials the addresses in the order of the resolver.
+	IPAny IPPreference = iota
+	// PreferIPv4 dials the IPv4 addresses first.
+	PreferIPv4
+	// PreferIPv6 dials the IPv6 addresses first.
+	PreferIPv6
+	// IPv4Only only dials IPv4 addresses.
+	IPv4Only
+	// IPv6Only only dials IPv6 addresses.
+	IPv6Only
+)
+
+// WithHostOverride connects to ips instead of the resolved addresses of
+// host, like curl --resolve. host is a host name or "host:port" to only
+// override that port. The request keeps its Host header and TLS server name.
+func WithHostOverride(host string, ips ...string) Option {
+	return func(c *Client) {
+		parsed := make([]net.IP, 0, len(ips))
+		for _, s := range ips {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				c.setErr(errors.New("invalid ip " + s + " for host " + host))
+				return
+			}
+			parsed = append(parsed, ip)
+		}
+		r := c.resolver()
+		if r.overrides == nil {
+			r.overrides = make(map[string][]net.IP)
+		}
+		r.overrides[host] = parsed
+	}
+}
+
+// WithDNSCache caches the resolved addresses of a host for the TTL of its
+// DNS records, at most maxTTL. Names of the hosts file are cached for
+// maxTTL. A zero maxTTL disables the cache.
+func WithDNSCache(maxTTL time.Duration) Option {
+	return func(c *Client) {
+		c.resolver().maxTTL = maxTTL
+	}
+}
+
+// WithDNSServer sends the DNS queries to the server addr, "host:port" or a
+// host with the port 53, instead of the servers of the system configuration.
+func WithDNSServer(addr string) Option {
+	return func(c *Client) {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		c.resolver().server = addr
+	}
+}
+
+// WithIPPreference sets the address family of the dialed addresses.
+func WithIPPreference(p IPPreference) Option {
+	return func(c *Client) {
+		c.resolver().preference = p
+	}
+}
+
+// resolver returns the resolver of the dialer, creating it if needed.
+func (c *Client) resolver() *resolver {
+	if c.dns == nil {
+		c.dns = &resolver{cache: make(map[string]dnsEntry)}
+		c.dns.r = &net.Resolver{PreferGo: true, Dial: c.dns.dial}
+	}
+	c.transport().DialContext = c.dialContext
+	return c.dns
+}
+
+// resolver resolves the addresses dialed by a client.
+type resolver struct {
+	r          *net.Resolver
+	overrides  map[string][]net.IP
+	server     string
+	preference IPPreference
+	maxTTL     time.Duration
+
+	mu    sync.Mutex
+	cache map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// resolveAddr returns the "ip:port" addresses to dial for addr, in order.
+func (r *resolver) resolveAddr(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, ok := r.overrides[addr]
+	if !ok {
+		ips, ok = r.overrides[host]
+	}
+	if !ok {
+		if ips, err = r.lookup(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	ips = r.order(ips)
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no address of the preferred family", Name: host, IsNotFound: true}
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return addrs, nil
+}
+
+func (r *resolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if r.maxTTL > 0 {
+		r.mu.Lock()
+		e, ok := r.cache[host]
+		r.mu.Unlock()
+		if ok && time.Now().Before(e.expires) {
+			return e.ips, nil
+		}
+	}
+
+	rec := &ttlRecorder{}
+	addrs, err := r.r.LookupIPAddr(context.WithValue(ctx, ttlRecorderKey{}, rec), host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	if r.maxTTL > 0 {
+		ttl := r.maxTTL
+		if recorded, ok := rec.get(); ok && recorded < ttl {
+			ttl = recorded
+		}
+		now := time.Now()
+		r.mu.Lock()
+		// drop the expired hosts so the cache does not grow with every
+		// host ever dialed
+		for h, e := range r.cache {
+			if !now.Before(e.expires) {
+				delete(r.cache, h)
+			}
+		}
+		r.cache[host] = dnsEntry{ips: ips, expires: now.Add(ttl)}
+		r.mu.Unlock()
+	}
+	return ips, nil
+}
+
+// order filters and sorts ips by the address family preference.
+func (r *resolver) order(ips []net.IP) []net.IP {
+	if r.preference == IPAny {
+		return ips
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		v4 := ip.To4() != nil
+		if (r.preference == IPv4Only && !v4) || (r.preference == IPv6Only && v4) {
+			continue
+		}
+		ordered = append(ordered, ip)
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		vi, vj := ordered[i].To4() != nil, ordered[j].To4() != nil
+		if r.preference == PreferIPv6 {
+			return !vi && vj
+		}
+		return vi && !vj
+	})
+	return ordered
+}
+
+// dial connects the Go resolver to the DNS server and records the TTL of
+// the answers read for a lookup.
+func (r *resolver) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if r.server != "" {
+		address = r.server
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := ctx.Value(ttlRecorderKey{}).(*ttlRecorder)
+	if !ok {
+		return conn, nil
+	}
+	tc := &ttlConn{Conn: conn, rec: rec}
+	if pc, ok := conn.(net.PacketConn); ok {
+		// the resolver frames the messages by the connection type
+		return &ttlPacketConn{ttlConn: tc, pc: pc}, nil
+	}
+	return tc, nil
+}
+
+type ttlRecorderKey struct{}
+
+// ttlRecorder keeps the lowest TTL of the address records of a lookup.
+type ttlRecorder struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	set bool
+}
+
+func (rec *ttlRecorder) observe(ttl time.Duration) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if !rec.set || ttl < rec.ttl {
+		rec.ttl, rec.set = ttl, true
+	}
+}
+
+func (rec *ttlRecorder) get() (time.Duration, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.ttl, rec.set
+}
+
+// ttlConn parses the DNS messages read from the server. The length prefix
+// of a message over TCP is read separately by the resolver and fails to
+// parse, which is ignored.
+type ttlConn struct {
+	net.Conn
+	rec *ttlRecorder
+}
+
+func (c *ttlConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.parse(b[:n])
+	}
+	return n, err
+}
+
+type ttlPacketConn struct {
+	*ttlConn
+	pc net.PacketConn
+}
+
+func (c *ttlPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.pc.ReadFrom(b)
+	if n > 0 {
+		c.parse(b[:n])
+	}
+	return n, addr, err
+}
+
+func (c *ttlPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(b, addr)
+}
+
+func (c *ttlConn) parse(msg []byte) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			return
+		}
+		if h.Type == dnsmessage.TypeA || h.Type == dnsmessage.TypeAAAA {
+			c.rec.observe(time.Duration(h.TTL) * time.Second)
+		}
+		if err := p.SkipAnswer(); err != nil {
+			return
+		}
+	}
+}
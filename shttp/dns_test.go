@@ -0,0 +1,122 @@
+package shttp_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsServer answers the A queries with 127.0.0.1 and counts them.
+func dnsServer(t *testing.T, ttl uint32) (string, *int32) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	var queries int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if req.Unpack(buf[:n]) != nil || len(req.Questions) == 0 {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, RecursionAvailable: true},
+				Questions: req.Questions,
+			}
+			if q.Type == dnsmessage.TypeA {
+				atomic.AddInt32(&queries, 1)
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: ttl},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			}
+			if b, err := resp.Pack(); err == nil {
+				pc.WriteTo(b, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String(), &queries
+}
+
+func Test_Client_DNS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	body, err := shttp.New(shttp.WithHostOverride("pinned.test", "127.0.0.1")).GetToString("http://pinned.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "pinned.test:"+port {
+		t.Fatalf("unexpected host %q", body)
+	}
+	if _, err := shttp.New(shttp.WithHostOverride("pinned.test", "127.0.0.1"), shttp.WithIPPreference(shttp.IPv6Only)).
+		Get("http://pinned.test:" + port); err == nil {
+		t.Fatal("IPv4 address dialed with IPv6Only")
+	}
+
+	addr, queries := dnsServer(t, 1)
+	client := shttp.New(shttp.WithDNSServer(addr), shttp.WithDNSCache(time.Minute), shttp.WithPool(shttp.PoolConfig{DisableKeepAlives: true}))
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetToString("http://svc.test:" + port); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(queries); n != 1 {
+		t.Fatalf("expected 1 cached query, got %d", n)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := client.GetToString("http://svc.test:" + port); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(queries); n != 2 {
+		t.Fatalf("record TTL not respected, got %d queries", n)
+	}
+}
+
+func Test_Client_DialTimeoutPerAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	// 127.0.0.2 never answers, the dial must leave time for 127.0.0.1
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if strings.HasPrefix(addr, "127.0.0.2:") {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	client := shttp.New(
+		shttp.WithDialContext(dial),
+		shttp.WithHostOverride("multi.test", "127.0.0.2", "127.0.0.1"),
+		shttp.WithTimeouts(shttp.Timeouts{Dial: 400 * time.Millisecond}),
+	)
+	body, err := client.GetToString("http://multi.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "ok" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
@@ -0,0 +1,508 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoEndpointAvailable is returned when every endpoint of a group is ejected.
+var ErrNoEndpointAvailable = errors.New("no endpoint available")
+
+// Balancing is the way an EndpointGroup picks the endpoint of a request.
+type Balancing int
+
+const (
+	// BalanceRoundRobin cycles through the healthy endpoints.
+	BalanceRoundRobin Balancing = iota
+	// BalanceWeighted spreads the requests by the endpoint weights, see
+	// WithEndpointWeight.
+	BalanceWeighted
+	// BalanceLeastInFlight picks the endpoint with the fewest requests in
+	// flight. A request is in flight until its response body is closed.
+	BalanceLeastInFlight
+	// BalanceConsistentHash keeps the same endpoint for the same key while
+	// the endpoints are unchanged, see WithEndpointHashKey and
+	// Request.EndpointKey.
+	BalanceConsistentHash
+)
+
+const endpointHashReplicas = 64
+
+type EndpointGroupOption func(*EndpointGroup)
+
+// WithBalancing sets how the endpoint of a request is picked, round-robin by
+// default.
+func WithBalancing(b Balancing) EndpointGroupOption {
+	return func(g *EndpointGroup) {
+		g.balancing = b
+	}
+}
+
+// WithEndpointWeight sets the weight of the endpoint rawUrl, 1 by default.
+func WithEndpointWeight(rawUrl string, weight int) EndpointGroupOption {
+	return func(g *EndpointGroup) {
+		g.weights[rawUrl] = weight
+	}
+}
+
+// WithEndpointMaxFailures ejects an endpoint after n consecutive failures, 3
+// by default.
+func WithEndpointMaxFailures(n int) EndpointGroupOption {
+	return func(g *EndpointGroup) {
+		g.maxFailures = n
+	}
+}
+
+// WithEndpointEjectTimeout re-admits an ejected endpoint after d when no
+// health check is configured, 30 seconds by default.
+func WithEndpointEjectTimeout(d time.Duration) EndpointGroupOption {
+	return func(g *EndpointGroup) {
+		g.ejectTimeout = d
+	}
+}
+
+// WithEndpointHealthCheck sends a GET of path to every endpoint every
+// interval, endpoints that fail or answer with a status of 400 or more are
+// ejected until a check succeeds. The checks use the transport of the first
+// client the group is added to, so its TLS, proxy and dialer settings, and
+// start with that client.
+func WithEndpointHealthCheck(path string, interval time.Duration) EndpointGroupOption {
+	return func(g *EndpointGroup) {
+		g.checkPath = path
+		g.checkInterval = interval
+	}
+}
+
+// WithEndpointRetries retries a failed GET, HEAD, OPTIONS or TRACE request
+// on up to n other endpoints, 1 by default.
+func WithEndpointRetries(n int) EndpointGroupOption {
+	return func(g *EndpointGroup) {
+		g.retries = n
+	}
+}
+
+// WithEndpointHashKey sets the key of BalanceConsistentHash, the request
+// path by default. Request.EndpointKey takes precedence.
+func WithEndpointHashKey(fn func(r *http.Request) string) EndpointGroupOption {
+	return func(g *EndpointGroup) {
+		g.hashKey = fn
+	}
+}
+
+// EndpointStats are the counters of an endpoint in a group.
+type EndpointStats struct {
+	Url                 *url.URL
+	Weight              int
+	Requests            int64
+	Failures            int64
+	ConsecutiveFailures int
+	InFlight            int
+	Ejected             bool
+	EjectedAt           time.Time
+	LastError           error
+}
+
+type groupEndpoint struct {
+	stats   EndpointStats
+	current int
+}
+
+// EndpointGroup spreads the requests for a logical service across the base
+// urls of its replicas. Plug it into a client with WithEndpointGroup, the
+// requests for the host name of the group, e.g. http://users/v1/list for
+// the group "users", are sent to one of its endpoints.
+type EndpointGroup struct {
+	name string
+
+	mu        sync.Mutex
+	endpoints []*groupEndpoint
+	next      int
+	ring      []endpointHash
+	checkStop chan struct{}
+	closeOnce sync.Once
+	// checkTransport is the transport of the first client of the group,
+	// the health checks wait for it
+	checkTransport http.RoundTripper
+
+	balancing     Balancing
+	weights       map[string]int
+	maxFailures   int
+	ejectTimeout  time.Duration
+	checkPath     string
+	checkInterval time.Duration
+	retries       int
+	hashKey       func(r *http.Request) string
+}
+
+type endpointHash struct {
+	hash uint32
+	ep   *groupEndpoint
+}
+
+// NewEndpointGroup returns the group name of the endpoint base urls. Close
+// stops the health checks.
+func NewEndpointGroup(name string, endpoints []string, opts ...EndpointGroupOption) (*EndpointGroup, error) {
+	g := &EndpointGroup{
+		name:         name,
+		checkStop:    make(chan struct{}),
+		weights:      make(map[string]int),
+		maxFailures:  3,
+		ejectTimeout: 30 * time.Second,
+		retries:      1,
+		hashKey: func(r *http.Request) string {
+			return r.URL.Path
+		},
+	}
+	for _, o := range opts {
+		o(g)
+	}
+	for _, raw := range endpoints {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, errors.New("endpoint " + raw + " is not an absolute url")
+		}
+		weight, ok := g.weights[raw]
+		if !ok {
+			weight = 1
+		}
+		g.add(u, weight)
+	}
+	if g.checkPath != "" && g.checkInterval > 0 {
+		go g.checkLoop()
+	}
+	return g, nil
+}
+
+// Name returns the host name of the logical service.
+func (g *EndpointGroup) Name() string {
+	return g.name
+}
+
+// Add adds an endpoint to the group.
+func (g *EndpointGroup) Add(u *url.URL, weight int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.add(u, weight)
+}
+
+func (g *EndpointGroup) add(u *url.URL, weight int) {
+	for _, ep := range g.endpoints {
+		if ep.stats.Url.String() == u.String() {
+			return
+		}
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	g.endpoints = append(g.endpoints, &groupEndpoint{stats: EndpointStats{Url: u, Weight: weight}})
+	g.buildRing()
+}
+
+// Remove removes an endpoint from the group.
+func (g *EndpointGroup) Remove(u *url.URL) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, ep := range g.endpoints {
+		if ep.stats.Url.String() == u.String() {
+			g.endpoints = append(g.endpoints[:i], g.endpoints[i+1:]...)
+			break
+		}
+	}
+	g.buildRing()
+}
+
+// Stats returns a snapshot of the counters of every endpoint.
+func (g *EndpointGroup) Stats() []EndpointStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats := make([]EndpointStats, 0, len(g.endpoints))
+	for _, ep := range g.endpoints {
+		stats = append(stats, ep.stats)
+	}
+	return stats
+}
+
+// Close stops the health checks.
+func (g *EndpointGroup) Close() {
+	g.closeOnce.Do(func() {
+		close(g.checkStop)
+	})
+}
+
+// buildRing places every endpoint on the hash ring, weighted replicas each.
+func (g *EndpointGroup) buildRing() {
+	g.ring = g.ring[:0]
+	for _, ep := range g.endpoints {
+		for i := 0; i < endpointHashReplicas*ep.stats.Weight; i++ {
+			h := crc32.ChecksumIEEE([]byte(ep.stats.Url.String() + "#" + strconv.Itoa(i)))
+			g.ring = append(g.ring, endpointHash{hash: h, ep: ep})
+		}
+	}
+	sort.Slice(g.ring, func(i, j int) bool { return g.ring[i].hash < g.ring[j].hash })
+}
+
+// pick selects the endpoint of r out of the healthy endpoints not tried yet.
+func (g *EndpointGroup) pick(r *http.Request, tried map[*groupEndpoint]bool) (*groupEndpoint, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	healthy := make([]*groupEndpoint, 0, len(g.endpoints))
+	usable := make(map[*groupEndpoint]bool, len(g.endpoints))
+	for _, ep := range g.endpoints {
+		if ep.stats.Ejected && g.checkPath == "" && time.Since(ep.stats.EjectedAt) >= g.ejectTimeout {
+			ep.readmit()
+		}
+		if !ep.stats.Ejected && !tried[ep] {
+			healthy = append(healthy, ep)
+			usable[ep] = true
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, ErrNoEndpointAvailable
+	}
+
+	var ep *groupEndpoint
+	switch g.balancing {
+	case BalanceWeighted:
+		// smooth weighted round-robin
+		total := 0
+		for _, h := range healthy {
+			h.current += h.stats.Weight
+			total += h.stats.Weight
+			if ep == nil || h.current > ep.current {
+				ep = h
+			}
+		}
+		ep.current -= total
+	case BalanceLeastInFlight:
+		ep = healthy[0]
+		for _, h := range healthy[1:] {
+			if h.stats.InFlight < ep.stats.InFlight {
+				ep = h
+			}
+		}
+	case BalanceConsistentHash:
+		key, ok := r.Context().Value(endpointKey{}).(string)
+		if !ok {
+			key = g.hashKey(r)
+		}
+		h := crc32.ChecksumIEEE([]byte(key))
+		i := sort.Search(len(g.ring), func(i int) bool { return g.ring[i].hash >= h })
+		for n := 0; n < len(g.ring); n++ {
+			if e := g.ring[(i+n)%len(g.ring)].ep; usable[e] {
+				ep = e
+				break
+			}
+		}
+	default:
+		ep = healthy[g.next%len(healthy)]
+		g.next++
+	}
+	ep.stats.Requests++
+	ep.stats.InFlight++
+	return ep, nil
+}
+
+// done records the outcome of a request sent to ep. The request stays in
+// flight until release.
+func (g *EndpointGroup) done(ep *groupEndpoint, resp *http.Response, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)):
+		return
+	case err == nil && resp.StatusCode < http.StatusInternalServerError:
+		ep.stats.ConsecutiveFailures = 0
+		return
+	case err == nil:
+		err = errors.New("endpoint response status " + resp.Status)
+	}
+	ep.stats.Failures++
+	ep.stats.ConsecutiveFailures++
+	ep.stats.LastError = err
+	if g.maxFailures > 0 && ep.stats.ConsecutiveFailures >= g.maxFailures {
+		ep.eject()
+	}
+}
+
+// release ends a request sent to ep, once its response body is closed.
+func (g *EndpointGroup) release(ep *groupEndpoint) {
+	g.mu.Lock()
+	ep.stats.InFlight--
+	g.mu.Unlock()
+}
+
+func (ep *groupEndpoint) eject() {
+	if !ep.stats.Ejected {
+		ep.stats.Ejected = true
+		ep.stats.EjectedAt = time.Now()
+	}
+}
+
+func (ep *groupEndpoint) readmit() {
+	ep.stats.Ejected = false
+	ep.stats.ConsecutiveFailures = 0
+}
+
+// useTransport sends the health checks with the transport of a client of
+// the group, so they use its TLS, proxy and dialer settings.
+func (g *EndpointGroup) useTransport(rt http.RoundTripper) {
+	g.mu.Lock()
+	if g.checkTransport == nil {
+		g.checkTransport = rt
+	}
+	g.mu.Unlock()
+}
+
+func (g *EndpointGroup) checkLoop() {
+	ticker := time.NewTicker(g.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.checkStop:
+			return
+		case <-ticker.C:
+		}
+		g.mu.Lock()
+		endpoints := append([]*groupEndpoint(nil), g.endpoints...)
+		transport := g.checkTransport
+		g.mu.Unlock()
+		if transport == nil {
+			continue
+		}
+		hc := &http.Client{Transport: transport, Timeout: g.checkInterval}
+		for _, ep := range endpoints {
+			healthy := g.check(hc, ep.stats.Url)
+			g.mu.Lock()
+			if healthy {
+				ep.readmit()
+			} else {
+				ep.eject()
+			}
+			g.mu.Unlock()
+		}
+	}
+}
+
+func (g *EndpointGroup) check(hc *http.Client, base *url.URL) bool {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(g.checkPath, "/")
+	u.RawPath = ""
+	resp, err := hc.Get(u.String())
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+type endpointKey struct{}
+
+// EndpointKey sets the key used by a BalanceConsistentHash group for this
+// request.
+func (r *Request) EndpointKey(key string) {
+	r.setContextValue(endpointKey{}, key)
+}
+
+// WithEndpointGroup sends the requests for the host name of the group to
+// its endpoints, see EndpointGroup.
+func WithEndpointGroup(g *EndpointGroup) Option {
+	return func(c *Client) {
+		if c.endpoints == nil {
+			c.endpoints = make(map[string]*EndpointGroup)
+		}
+		c.endpoints[g.name] = g
+	}
+}
+
+// endpointRoundTripper rewrites the requests of the logical services to
+// the endpoints of their group and retries the safe methods on another
+// endpoint.
+type endpointRoundTripper struct {
+	next   http.RoundTripper
+	groups map[string]*EndpointGroup
+}
+
+func (rt *endpointRoundTripper) unwrap() http.RoundTripper {
+	return rt.next
+}
+
+func (rt *endpointRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	g, ok := rt.groups[req.URL.Host]
+	if !ok {
+		return rt.next.RoundTrip(req)
+	}
+	attempts := 1
+	if retryable(req) {
+		attempts += g.retries
+	}
+	tried := make(map[*groupEndpoint]bool, attempts)
+	var (
+		resp *http.Response
+		err  error
+	)
+	for i := 0; i < attempts; i++ {
+		ep, pickErr := g.pick(req, tried)
+		if pickErr != nil {
+			if resp != nil || err != nil {
+				break
+			}
+			return nil, pickErr
+		}
+		tried[ep] = true
+		if resp != nil {
+			resp.Body.Close()
+		}
+		r := req.Clone(req.Context())
+		if i > 0 && req.Body != nil && req.Body != http.NoBody {
+			if r.Body, err = req.GetBody(); err != nil {
+				g.done(ep, nil, context.Canceled)
+				g.release(ep)
+				return nil, err
+			}
+		}
+		rewriteEndpoint(r, ep.stats.Url)
+		resp, err = rt.next.RoundTrip(r)
+		g.done(ep, resp, err)
+		releaseOnResponse(resp, err, func() { g.release(ep) })
+		if (err == nil && resp.StatusCode < http.StatusInternalServerError) || req.Context().Err() != nil {
+			break
+		}
+	}
+	return resp, err
+}
+
+// retryable reports whether the request is safe to send to another endpoint.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+// rewriteEndpoint sends r to the base url, keeping the path and query below
+// the base path.
+func rewriteEndpoint(r *http.Request, base *url.URL) {
+	u := *r.URL
+	u.Scheme, u.Host, u.User = base.Scheme, base.Host, base.User
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(base.EscapedPath(), "/") + u.RawPath
+	}
+	u.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+	if r.Host == r.URL.Host {
+		r.Host = ""
+	}
+	r.URL = &u
+}
@@ -0,0 +1,176 @@
+package shttp_test
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func endpointServer(name string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(name + " " + r.URL.Path))
+	}))
+}
+
+// holdingEventServer sends one event and keeps the stream open until the
+// client goes away.
+func holdingEventServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: open\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+func Test_EndpointGroup_Retry(t *testing.T) {
+	down := endpointServer("down", http.StatusServiceUnavailable)
+	defer down.Close()
+	up := endpointServer("up", http.StatusOK)
+	defer up.Close()
+
+	group, err := shttp.NewEndpointGroup("users", []string{down.URL + "/api", up.URL + "/api"},
+		shttp.WithEndpointMaxFailures(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.Close()
+	client := shttp.New(shttp.WithEndpointGroup(group))
+	for i := 0; i < 3; i++ {
+		body, err := client.GetToString("http://users/v1/list")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "up /api/v1/list" {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+	stats := group.Stats()
+	if !stats[0].Ejected || stats[0].Requests != 1 || stats[1].Requests != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	resp, err := client.Post("http://users/v1/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Ok() {
+		t.Fatalf("POST sent to the ejected endpoint, status %d", resp.Response().StatusCode)
+	}
+}
+
+func Test_EndpointGroup_Balancing(t *testing.T) {
+	a := endpointServer("a", http.StatusOK)
+	defer a.Close()
+	b := endpointServer("b", http.StatusOK)
+	defer b.Close()
+
+	group, err := shttp.NewEndpointGroup("svc", []string{a.URL, b.URL}, shttp.WithBalancing(shttp.BalanceConsistentHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.Close()
+	client := shttp.New(shttp.WithEndpointGroup(group))
+	first, err := client.GetToString("http://svc/item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if body, _ := client.GetToString("http://svc/item"); body != first {
+			t.Fatalf("consistent hash moved from %q to %q", first, body)
+		}
+	}
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("unhealthy"))
+	}))
+	defer unhealthy.Close()
+	checked, err := shttp.NewEndpointGroup("svc", []string{unhealthy.URL, a.URL},
+		shttp.WithEndpointHealthCheck("/health", 20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checked.Close()
+	// the checks start with the first client of the group
+	client = shttp.New(shttp.WithEndpointGroup(checked))
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		if body, _ := client.GetToString("http://svc/item"); body != "a /item" {
+			t.Fatalf("request sent to an unhealthy endpoint, got %q", body)
+		}
+	}
+}
+
+func Test_EndpointGroup_HealthCheckTransport(t *testing.T) {
+	handler := func(healthy bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" && !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		}
+	}
+	healthy := httptest.NewTLSServer(handler(true))
+	defer healthy.Close()
+	unhealthy := httptest.NewTLSServer(handler(false))
+	defer unhealthy.Close()
+
+	g, err := shttp.NewEndpointGroup("svc", []string{healthy.URL, unhealthy.URL},
+		shttp.WithEndpointHealthCheck("/health", 20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	// both servers share the certificate of httptest, trusted by the client only
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: healthy.Certificate().Raw})
+	shttp.New(shttp.WithRootCA(ca), shttp.WithEndpointGroup(g))
+	time.Sleep(150 * time.Millisecond)
+
+	for _, s := range g.Stats() {
+		if want := s.Url.Host == strings.TrimPrefix(unhealthy.URL, "https://"); s.Ejected != want {
+			t.Fatalf("endpoint %s ejected %v, last error %v", s.Url, s.Ejected, s.LastError)
+		}
+	}
+}
+
+func Test_EndpointGroup_InFlightUntilBodyClosed(t *testing.T) {
+	srv := holdingEventServer()
+	defer srv.Close()
+
+	group, err := shttp.NewEndpointGroup("events", []string{srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.Close()
+	client := shttp.New(shttp.WithEndpointGroup(group))
+	errStop := errors.New("stop")
+	err = client.EventStream("http://events/").Subscribe(context.Background(), func(e *shttp.Event) error {
+		if n := group.Stats()[0].InFlight; n != 1 {
+			t.Errorf("%d requests in flight while reading the body", n)
+		}
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatal(err)
+	}
+	if n := group.Stats()[0].InFlight; n != 0 {
+		t.Fatalf("%d requests in flight after the body was closed", n)
+	}
+}
@@ -0,0 +1,193 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/smalls0098/pkg/cryptor"
+)
+
+// AESKeyFunc returns the AES key, 16, 24 or 32 bytes, and the 16 bytes IV of
+// a request. It is called once per request, the same key and IV decrypt its
+// response.
+type AESKeyFunc func(req *Request) (key, iv []byte, err error)
+
+// StaticAESKey uses the same key and IV for every request.
+func StaticAESKey(key, iv []byte) AESKeyFunc {
+	return func(req *Request) ([]byte, []byte, error) {
+		return key, iv, nil
+	}
+}
+
+// AESEnvelope configures the AES-CBC encryption of request and response
+// bodies, base64 encoded and optionally wrapped in a JSON object.
+type AESEnvelope struct {
+	Key AESKeyFunc
+	// Field wraps the ciphertext in a JSON object, e.g. "data" for
+	// {"data":"..."}. Empty sends and expects the bare base64 text. A
+	// response without the field, or without base64 ciphertext when Field is
+	// empty, is left as is, like a plain error reply.
+	Field string
+	// IVField sends a random IV per request in this field of the envelope
+	// and reads the IV of the response from it, the IV of Key is not used.
+	IVField string
+	// ContentType is the type of the encrypted request, application/json
+	// with a Field and text/plain otherwise.
+	ContentType string
+	// PlainContentType replaces the type of a decrypted response,
+	// application/json by default.
+	PlainContentType string
+}
+
+// WithAESEnvelope encrypts the request bodies and decrypts the response
+// bodies, see AESEnvelopeMiddleware.
+func WithAESEnvelope(conf AESEnvelope) Option {
+	return WithMiddleware(AESEnvelopeMiddleware(conf))
+}
+
+// AESEnvelopeMiddleware encrypts the request body with AES-CBC and PKCS#7
+// padding before sending and decrypts the response body on receipt, see
+// AESEnvelope. Requests without a body are sent as is.
+func AESEnvelopeMiddleware(conf AESEnvelope) Middleware {
+	if conf.ContentType == "" {
+		conf.ContentType = "text/plain"
+		if conf.Field != "" {
+			conf.ContentType = httpHeaderContentTypeJson
+		}
+	}
+	if conf.PlainContentType == "" {
+		conf.PlainContentType = httpHeaderContentTypeJson
+	}
+	// ctxKey is distinct per middleware, so the keys of two envelopes of a
+	// client do not mix
+	ctxKey := aesKeysKey{new(byte)}
+	return func(c *Client, req *Request, resp *Response) error {
+		if conf.Key == nil {
+			return errors.New("aes envelope: no key")
+		}
+		if resp != nil {
+			if keys, ok := req.Context().Value(ctxKey).(aesKeys); ok {
+				return conf.decrypt(resp, keys.key, keys.iv)
+			}
+		}
+		key, iv, err := conf.Key(req)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			req.WithContext(context.WithValue(req.Context(), ctxKey, aesKeys{key: key, iv: iv}))
+			return conf.encrypt(req, key, iv)
+		}
+		return conf.decrypt(resp, key, iv)
+	}
+}
+
+type aesKeysKey struct{ id *byte }
+
+// aesKeys are the key and IV of a request, reused for its response.
+type aesKeys struct {
+	key, iv []byte
+}
+
+func (conf AESEnvelope) encrypt(req *Request, key, iv []byte) error {
+	req.buildFormBody()
+	if req.bodyFunc != nil {
+		rc, err := req.bodyFunc()
+		if err != nil {
+			return err
+		}
+		req.body, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		req.bodyFunc = nil
+	}
+	if len(req.body) == 0 {
+		return nil
+	}
+	if conf.IVField != "" {
+		iv = make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return err
+		}
+	}
+	if err := checkAESKey(key, iv); err != nil {
+		return err
+	}
+	data := cryptor.Base64StdEncode(cryptor.AesCbcEncrypt(req.body, key, iv))
+	if conf.Field != "" {
+		envelope := map[string]string{conf.Field: string(data)}
+		if conf.IVField != "" {
+			envelope[conf.IVField] = string(cryptor.Base64StdEncode(iv))
+		}
+		var err error
+		if data, err = json.Marshal(envelope); err != nil {
+			return err
+		}
+	}
+	req.body = data
+	req.ContentType(conf.ContentType)
+	return nil
+}
+
+func (conf AESEnvelope) decrypt(resp *Response, key, iv []byte) error {
+	bs, err := resp.Bytes()
+	if err != nil || len(bs) == 0 {
+		return err
+	}
+	data := bs
+	if conf.Field != "" {
+		var envelope map[string]interface{}
+		if json.Unmarshal(bs, &envelope) != nil {
+			return nil
+		}
+		field, ok := envelope[conf.Field].(string)
+		if !ok {
+			return nil
+		}
+		data = []byte(field)
+		if conf.IVField != "" {
+			encoded, _ := envelope[conf.IVField].(string)
+			if iv = cryptor.Base64StdDecode([]byte(encoded)); len(iv) == 0 {
+				return errors.New("aes envelope: invalid response iv")
+			}
+		}
+	}
+	if err := checkAESKey(key, iv); err != nil {
+		return err
+	}
+	// Base64StdDecode returns no bytes for invalid base64
+	encrypted := cryptor.Base64StdDecode(bytes.TrimSpace(data))
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		if conf.Field == "" {
+			return nil
+		}
+		return errors.New("aes envelope: invalid base64 response")
+	}
+	plain, err := cryptor.AesCbcDecryptChecked(encrypted, key, iv)
+	if err != nil {
+		return fmt.Errorf("aes envelope: %w", err)
+	}
+	resp.body = plain
+	resp.resp.Header.Set(httpHeaderContentType, conf.PlainContentType)
+	return nil
+}
+
+func checkAESKey(key, iv []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return errors.New("aes envelope: key must be 16, 24 or 32 bytes")
+	}
+	if len(iv) != aes.BlockSize {
+		return errors.New("aes envelope: iv must be 16 bytes")
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package shttp_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+// cbcEncrypt and cbcDecrypt are the server side of the envelope, AES-CBC
+// with PKCS#7 padding and base64.
+func cbcEncrypt(plain, key, iv []byte) string {
+	block, _ := aes.NewCipher(key)
+	n := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte(nil), plain...), bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return base64.StdEncoding.EncodeToString(padded)
+}
+
+func cbcDecrypt(encoded string, key, iv []byte) []byte {
+	encrypted, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return nil
+	}
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	return encrypted[:len(encrypted)-int(encrypted[len(encrypted)-1])]
+}
+
+func Test_Client_AESEnvelope(t *testing.T) {
+	key, iv := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope map[string]string
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/json" || json.Unmarshal(body, &envelope) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		plain := cbcDecrypt(envelope["data"], key, iv)
+		reply := cbcEncrypt([]byte(`{"echo":`+string(plain)+`}`), key, iv)
+		json.NewEncoder(w).Encode(map[string]string{"data": reply})
+	}))
+	defer srv.Close()
+
+	client := shttp.New(shttp.WithAESEnvelope(shttp.AESEnvelope{
+		Key:   shttp.StaticAESKey(key, iv),
+		Field: "data",
+	}))
+	resp, err := client.Post(srv.URL, func(c *shttp.Client, req *shttp.Request) {
+		req.BodyJSON(shttp.G{"user": "smalls"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := resp.JSONPath("echo.user").String(); user != "smalls" {
+		body, _ := resp.String()
+		t.Fatalf("unexpected decrypted body %q", body)
+	}
+
+	_, err = shttp.New(shttp.WithAESEnvelope(shttp.AESEnvelope{
+		Key:   shttp.StaticAESKey([]byte("short"), iv),
+		Field: "data",
+	})).Post(srv.URL, func(c *shttp.Client, req *shttp.Request) {
+		req.BodyJSON4Str(`{}`)
+	})
+	if err == nil {
+		t.Fatal("invalid key accepted")
+	}
+}
+
+func Test_Client_AESEnvelope_Padding(t *testing.T) {
+	key, iv := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// encrypted with another key, the padding does not match
+		w.Write([]byte(cbcEncrypt([]byte(`{"ok":true}`), []byte("abcdef0123456789"), iv)))
+	}))
+	defer srv.Close()
+
+	_, err := shttp.New(shttp.WithAESEnvelope(shttp.AESEnvelope{
+		Key: shttp.StaticAESKey(key, iv),
+	})).Get(srv.URL)
+	if err == nil {
+		t.Fatal("response of another key decrypted")
+	}
+}
+
+func Test_Client_AESEnvelope_KeyPerRequest(t *testing.T) {
+	iv := []byte("fedcba9876543210")
+	keyOf := func(id string) []byte {
+		return bytes.Repeat([]byte(id), 16)[:16]
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := keyOf(r.Header.Get("X-Key-Id"))
+		body, _ := io.ReadAll(r.Body)
+		plain := cbcDecrypt(string(body), key, iv)
+		w.Write([]byte(cbcEncrypt([]byte(`{"echo":`+string(plain)+`}`), key, iv)))
+	}))
+	defer srv.Close()
+
+	calls := 0
+	client := shttp.New(shttp.WithAESEnvelope(shttp.AESEnvelope{
+		// a new key on every call
+		Key: func(req *shttp.Request) ([]byte, []byte, error) {
+			calls++
+			id := string(rune('a' + calls))
+			req.Header("X-Key-Id", id)
+			return keyOf(id), iv, nil
+		},
+	}))
+	resp, err := client.Post(srv.URL, func(c *shttp.Client, req *shttp.Request) {
+		req.BodyJSON4Str(`{"n":1}`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := resp.JSONPath("echo.n").Int(); n != 1 || calls != 1 {
+		t.Fatalf("unexpected echo %d after %d key calls", n, calls)
+	}
+}
+
+func Test_Client_AESEnvelope_PlainError(t *testing.T) {
+	key, iv := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"unauthorized"}`))
+	}))
+	defer srv.Close()
+
+	resp, err := shttp.New(shttp.WithAESEnvelope(shttp.AESEnvelope{
+		Key: shttp.StaticAESKey(key, iv),
+	})).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := resp.JSONPath("error").String(); msg != "unauthorized" {
+		t.Fatalf("plain error body not passed through, got %q", msg)
+	}
+}
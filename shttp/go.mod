@@ -1,3 +1,9 @@
 module github.com/smalls0098/pkg/shttp
 
-go 1.18
\ No newline at end of file
+go 1.18
+
+require (
+	github.com/smalls0098/pkg/cryptor v0.1.0
+	golang.org/x/net v0.33.0
+	golang.org/x/text v0.21.0
+)
@@ -0,0 +1,186 @@
+package shttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GraphQLClient sends GraphQL operations over a Client, so its options and
+// middlewares apply.
+type GraphQLClient struct {
+	c        *Client
+	url      string
+	handlers []RequestHandler
+
+	persisted bool
+}
+
+// GraphQLRequest is a GraphQL operation.
+type GraphQLRequest struct {
+	Query         string                 `json:"query,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLLocation is a position in the query of a GraphQLError.
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLError is an entry of the errors array of a GraphQL response.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Locations  []GraphQLLocation      `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *GraphQLError) Error() string {
+	if len(e.Path) == 0 {
+		return "graphql: " + e.Message
+	}
+	path := make([]string, len(e.Path))
+	for i, p := range e.Path {
+		path[i] = fmt.Sprint(p)
+	}
+	return fmt.Sprintf("graphql: %s at %s", e.Message, strings.Join(path, "."))
+}
+
+// Code returns the extensions code of the error, e.g. "UNAUTHENTICATED".
+func (e *GraphQLError) Code() string {
+	code, _ := e.Extensions["code"].(string)
+	return code
+}
+
+// GraphQLErrors is the errors array of a GraphQL response.
+type GraphQLErrors []*GraphQLError
+
+func (e GraphQLErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors GraphQLErrors   `json:"errors"`
+}
+
+// GraphQL returns a GraphQL client of the endpoint url.
+func (c *Client) GraphQL(url string, handlers ...RequestHandler) *GraphQLClient {
+	return &GraphQLClient{
+		c:        c,
+		url:      url,
+		handlers: handlers,
+	}
+}
+
+// PersistedQueries sends the SHA-256 hash of the query instead of the query,
+// and the query with its hash when the server does not know it yet, like
+// Apollo automatic persisted queries.
+func (g *GraphQLClient) PersistedQueries(enabled bool) *GraphQLClient {
+	g.persisted = enabled
+	return g
+}
+
+// Query sends a query and decodes its data into target.
+func (g *GraphQLClient) Query(ctx context.Context, query string, variables map[string]interface{}, target interface{}) error {
+	return g.Do(ctx, GraphQLRequest{Query: query, Variables: variables}, target)
+}
+
+// Mutate sends a mutation and decodes its data into target.
+func (g *GraphQLClient) Mutate(ctx context.Context, mutation string, variables map[string]interface{}, target interface{}) error {
+	return g.Do(ctx, GraphQLRequest{Query: mutation, Variables: variables}, target)
+}
+
+// Do sends the operation and decodes the data of the response into target,
+// which may be nil. The errors of the response are returned as
+// GraphQLErrors, after decoding the partial data that came with them.
+func (g *GraphQLClient) Do(ctx context.Context, op GraphQLRequest, target interface{}, handlers ...RequestHandler) error {
+	if !g.persisted || op.Query == "" {
+		return g.send(ctx, op, target, handlers)
+	}
+	hash := sha256.Sum256([]byte(op.Query))
+	ext := make(map[string]interface{}, len(op.Extensions)+1)
+	for k, v := range op.Extensions {
+		ext[k] = v
+	}
+	ext["persistedQuery"] = map[string]interface{}{
+		"version":    1,
+		"sha256Hash": hex.EncodeToString(hash[:]),
+	}
+	op.Extensions = ext
+
+	hashed := op
+	hashed.Query = ""
+	err := g.send(ctx, hashed, target, handlers)
+	if errs, ok := err.(GraphQLErrors); ok && persistedQueryNotFound(errs) {
+		return g.send(ctx, op, target, handlers)
+	}
+	return err
+}
+
+func persistedQueryNotFound(errs GraphQLErrors) bool {
+	for _, e := range errs {
+		if e.Code() == "PERSISTED_QUERY_NOT_FOUND" || e.Message == "PersistedQueryNotFound" {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *GraphQLClient) send(ctx context.Context, op GraphQLRequest, target interface{}, handlers []RequestHandler) error {
+	httpReq, err := http.NewRequestWithContext(ctx, POST.String(), g.c.resolveURL(g.url), nil)
+	if err != nil {
+		return err
+	}
+	var bodyErr error
+	all := make([]RequestHandler, 0, len(g.handlers)+len(handlers)+1)
+	all = append(all, func(c *Client, req *Request) {
+		req.Header(httpHeaderAccept, httpHeaderContentTypeJson)
+		bodyErr = req.BodyJSON(op)
+	})
+	all = append(all, g.handlers...)
+	all = append(all, handlers...)
+	req := g.c.handlerRequest(httpReq, all...)
+	if bodyErr != nil {
+		return bodyErr
+	}
+	resp, err := g.c.Do(req)
+	if err != nil {
+		return err
+	}
+	bs, err := resp.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var gr graphQLResponse
+	if err := json.Unmarshal(bs, &gr); err != nil {
+		if resp.resp.StatusCode >= 300 {
+			return fmt.Errorf("graphql: response status %s", resp.resp.Status)
+		}
+		return err
+	}
+	if target != nil && len(gr.Data) > 0 && string(gr.Data) != "null" {
+		if err := json.Unmarshal(gr.Data, target); err != nil {
+			return err
+		}
+	}
+	if len(gr.Errors) > 0 {
+		return gr.Errors
+	}
+	if resp.resp.StatusCode >= 300 {
+		return fmt.Errorf("graphql: response status %s", resp.resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package shttp_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+type graphQLBody struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+func Test_GraphQL_Query(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body graphQLBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if body.OperationName != "User" || body.Variables["id"] != "1" || body.Query == "" {
+			t.Errorf("unexpected body %+v", body)
+		}
+		fmt.Fprint(w, `{"data":{"user":{"id":"1","name":"alice"}}}`)
+	}))
+	defer srv.Close()
+
+	var data struct {
+		User struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"user"`
+	}
+	err := shttp.New().GraphQL(srv.URL).Do(context.Background(), shttp.GraphQLRequest{
+		Query:         `query User($id: ID!) { user(id: $id) { id name } }`,
+		Variables:     map[string]interface{}{"id": "1"},
+		OperationName: "User",
+	}, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.User.Name != "alice" {
+		t.Fatalf("unexpected data %+v", data)
+	}
+}
+
+func Test_GraphQL_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"a":1,"b":null},"errors":[{"message":"denied","locations":[{"line":1,"column":5}],"path":["b",0],"extensions":{"code":"FORBIDDEN"}}]}`)
+	}))
+	defer srv.Close()
+
+	var data struct {
+		A int `json:"a"`
+	}
+	err := shttp.New().GraphQL(srv.URL).Query(context.Background(), `{ a b }`, nil, &data)
+	errs, ok := err.(shttp.GraphQLErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	e := errs[0]
+	if e.Message != "denied" || e.Code() != "FORBIDDEN" || len(e.Path) != 2 || e.Path[0] != "b" || e.Locations[0].Column != 5 {
+		t.Fatalf("unexpected graphql error %+v", e)
+	}
+	if e.Error() != "graphql: denied at b.0" {
+		t.Fatalf("unexpected message %q", e.Error())
+	}
+	if data.A != 1 {
+		t.Fatalf("partial data not decoded %+v", data)
+	}
+}
+
+func Test_GraphQL_Status(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	err := shttp.New().GraphQL(srv.URL).Query(context.Background(), `{ a }`, nil, nil)
+	if err == nil || err.Error() != "graphql: response status 502 Bad Gateway" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func Test_GraphQL_PersistedQueries(t *testing.T) {
+	var mu sync.Mutex
+	known := map[string]string{}
+	var requests []graphQLBody
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body graphQLBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, body)
+		pq, _ := body.Extensions["persistedQuery"].(map[string]interface{})
+		hash, _ := pq["sha256Hash"].(string)
+		if body.Query != "" {
+			known[hash] = body.Query
+		} else if _, ok := known[hash]; !ok {
+			fmt.Fprint(w, `{"errors":[{"message":"PersistedQueryNotFound","extensions":{"code":"PERSISTED_QUERY_NOT_FOUND"}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"ok":true}}`)
+	}))
+	defer srv.Close()
+
+	gql := shttp.New().GraphQL(srv.URL).PersistedQueries(true)
+	for i := 0; i < 2; i++ {
+		var data struct {
+			OK bool `json:"ok"`
+		}
+		if err := gql.Query(context.Background(), `{ ok }`, nil, &data); err != nil {
+			t.Fatal(err)
+		}
+		if !data.OK {
+			t.Fatalf("unexpected data %+v", data)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(requests))
+	}
+	if requests[0].Query != "" || requests[1].Query == "" || requests[2].Query != "" {
+		t.Fatalf("unexpected persisted query requests %+v", requests)
+	}
+	pq := requests[2].Extensions["persistedQuery"].(map[string]interface{})
+	sum := sha256.Sum256([]byte(`{ ok }`))
+	if pq["version"] != float64(1) || pq["sha256Hash"] != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected extension %+v", pq)
+	}
+}
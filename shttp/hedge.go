@@ -0,0 +1,285 @@
+package shttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HedgeConfig configures hedged requests: when the response is slow a
+// duplicate request is sent, the first successful response wins and the
+// other requests are canceled. Errors and 5xx responses are not successful,
+// a request that fails before the delay is hedged right away.
+type HedgeConfig struct {
+	// Delay is the wait before a hedge is sent, 100ms when zero or negative
+	// so that a request is never duplicated right away.
+	Delay time.Duration
+	// Percentile sends the hedge after this percentile of the recent response
+	// latencies, e.g. 0.95, instead of Delay once MinSamples responses were
+	// measured. Delay is used until then.
+	Percentile float64
+	// MinSamples is the number of latencies needed by Percentile, 20 when zero.
+	MinSamples int
+	// MaxHedges is the number of duplicates of a request, 1 when zero.
+	MaxHedges int
+	// BudgetRatio limits the hedges to this fraction of the requests, e.g.
+	// 0.1, with bursts of up to 10 hedges. Zero means no limit.
+	BudgetRatio float64
+	// Methods are the hedged methods, GET, HEAD and OPTIONS when empty.
+	// Requests with a body must be replayable.
+	Methods []string
+}
+
+// WithHedging sends hedged requests, see HedgeConfig.
+func WithHedging(conf HedgeConfig) Option {
+	return func(c *Client) {
+		c.hedge = &conf
+	}
+}
+
+type hedgeKey struct{}
+
+// Hedge turns hedging on or off for this request, e.g. to hedge an
+// idempotent POST.
+func (r *Request) Hedge(enabled bool) {
+	r.setContextValue(hedgeKey{}, enabled)
+}
+
+const (
+	hedgeLatencySamples = 100
+	hedgeMaxTokens      = 10
+	hedgeDefaultDelay   = 100 * time.Millisecond
+)
+
+// hedgeRoundTripper sends the hedges of a request through next.
+type hedgeRoundTripper struct {
+	next http.RoundTripper
+	conf HedgeConfig
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	pos       int
+	tokens    float64
+}
+
+func newHedgeRoundTripper(next http.RoundTripper, conf HedgeConfig) *hedgeRoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if conf.Delay <= 0 {
+		conf.Delay = hedgeDefaultDelay
+	}
+	if conf.MaxHedges <= 0 {
+		conf.MaxHedges = 1
+	}
+	if conf.MinSamples <= 0 {
+		conf.MinSamples = 20
+	}
+	if len(conf.Methods) == 0 {
+		conf.Methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	}
+	return &hedgeRoundTripper{next: next, conf: conf, tokens: hedgeMaxTokens}
+}
+
+func (rt *hedgeRoundTripper) unwrap() http.RoundTripper {
+	return rt.next
+}
+
+type hedgeResult struct {
+	i      int
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+}
+
+func (rt *hedgeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !rt.hedged(req) {
+		return rt.next.RoundTrip(req)
+	}
+	rt.earn()
+
+	results := make(chan hedgeResult, rt.conf.MaxHedges+1)
+	cancels := make([]context.CancelFunc, 0, rt.conf.MaxHedges+1)
+	start := time.Now()
+	send := func() error {
+		ctx, cancel := context.WithCancel(req.Context())
+		r := req.Clone(ctx)
+		if len(cancels) > 0 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return err
+			}
+			r.Body = body
+		}
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := rt.next.RoundTrip(r)
+			results <- hedgeResult{i: i, resp: resp, err: err, cancel: cancel}
+		}()
+		return nil
+	}
+	if err := send(); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(rt.delay())
+	defer timer.Stop()
+	pending := 1
+	last := hedgeResult{i: -1}
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) <= rt.conf.MaxHedges && rt.spend() && send() == nil {
+				pending++
+				timer.Reset(rt.delay())
+			}
+			continue
+		case res := <-results:
+			pending--
+			if res.err == nil && res.resp.StatusCode < http.StatusInternalServerError {
+				rt.observe(time.Since(start))
+				rt.finish(res.i, results, pending, cancels)
+				res.resp.Body = &cancelBody{ReadCloser: res.resp.Body, cancel: res.cancel}
+				return res.resp, nil
+			}
+			if last.resp != nil {
+				last.resp.Body.Close()
+			}
+			if last.i >= 0 {
+				last.cancel()
+			}
+			last = res
+		case <-req.Context().Done():
+			if last.resp != nil {
+				last.resp.Body.Close()
+			}
+			rt.finish(-1, results, pending, cancels)
+			return nil, req.Context().Err()
+		}
+		if pending > 0 {
+			continue
+		}
+		// every request failed, hedge right away while allowed
+		if len(cancels) <= rt.conf.MaxHedges && rt.spend() && send() == nil {
+			pending++
+			continue
+		}
+		if last.err != nil {
+			last.cancel()
+			return nil, last.err
+		}
+		last.resp.Body = &cancelBody{ReadCloser: last.resp.Body, cancel: last.cancel}
+		return last.resp, nil
+	}
+}
+
+// finish cancels every request but the winner, -1 for none, and discards
+// the responses still to come.
+func (rt *hedgeRoundTripper) finish(winner int, results chan hedgeResult, pending int, cancels []context.CancelFunc) {
+	for i, cancel := range cancels {
+		if i != winner {
+			cancel()
+		}
+	}
+	go func() {
+		for ; pending > 0; pending-- {
+			res := <-results
+			if res.resp != nil {
+				res.resp.Body.Close()
+			}
+			res.cancel()
+		}
+	}()
+}
+
+func (rt *hedgeRoundTripper) hedged(req *http.Request) bool {
+	if enabled, ok := req.Context().Value(hedgeKey{}).(bool); ok {
+		if !enabled {
+			return false
+		}
+	} else {
+		allowed := false
+		for _, m := range rt.conf.Methods {
+			allowed = allowed || strings.EqualFold(m, req.Method)
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// delay returns the wait before the next hedge.
+func (rt *hedgeRoundTripper) delay() time.Duration {
+	if rt.conf.Percentile <= 0 {
+		return rt.conf.Delay
+	}
+	rt.mu.Lock()
+	samples := append([]time.Duration(nil), rt.latencies...)
+	rt.mu.Unlock()
+	if len(samples) < rt.conf.MinSamples {
+		return rt.conf.Delay
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(rt.conf.Percentile * float64(len(samples)))
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	return samples[i]
+}
+
+func (rt *hedgeRoundTripper) observe(d time.Duration) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if len(rt.latencies) < hedgeLatencySamples {
+		rt.latencies = append(rt.latencies, d)
+		return
+	}
+	rt.latencies[rt.pos] = d
+	rt.pos = (rt.pos + 1) % hedgeLatencySamples
+}
+
+// earn adds the budget of a request.
+func (rt *hedgeRoundTripper) earn() {
+	if rt.conf.BudgetRatio <= 0 {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.tokens += rt.conf.BudgetRatio
+	if rt.tokens > hedgeMaxTokens {
+		rt.tokens = hedgeMaxTokens
+	}
+}
+
+// spend takes the budget of a hedge, false when it is exhausted.
+func (rt *hedgeRoundTripper) spend() bool {
+	if rt.conf.BudgetRatio <= 0 {
+		return true
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.tokens < 1 {
+		return false
+	}
+	rt.tokens--
+	return true
+}
+
+// cancelBody cancels the context of its request when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
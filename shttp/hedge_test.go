@@ -0,0 +1,73 @@
+package shttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_Hedging(t *testing.T) {
+	var requests, canceled int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%2 == 1 {
+			select {
+			case <-r.Context().Done():
+				atomic.AddInt32(&canceled, 1)
+				return
+			case <-time.After(time.Second):
+			}
+			w.Write([]byte("slow"))
+			return
+		}
+		w.Write([]byte("fast"))
+	}))
+	defer srv.Close()
+
+	client := shttp.New(shttp.WithHedging(shttp.HedgeConfig{Delay: 20 * time.Millisecond}))
+	start := time.Now()
+	body, err := client.GetToString(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "fast" || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("hedge did not win, got %q after %s", body, time.Since(start))
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&canceled) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&canceled) != 1 {
+		t.Fatal("slow request not canceled")
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	body, err = client.PostToString(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "slow" || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("POST hedged, got %q with %d requests", body, atomic.LoadInt32(&requests))
+	}
+}
+
+func Test_Client_Hedging_ZeroDelay(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := shttp.New(shttp.WithHedging(shttp.HedgeConfig{MaxHedges: 3}))
+	if _, err := client.GetToString(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("%d requests sent without a delay", n)
+	}
+}
@@ -0,0 +1,220 @@
+package shttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP2Config configures HTTP/2 support of a Client.
+type HTTP2Config struct {
+	// Force sends https requests over HTTP/2 only, the request fails when
+	// the server does not negotiate h2 through ALPN.
+	Force bool
+	// H2C sends http requests as prior-knowledge cleartext HTTP/2, for
+	// internal services that speak h2c without an upgrade.
+	H2C bool
+	// ReadIdleTimeout sends a health check ping when no frame was received
+	// for the duration, zero disables the health check.
+	ReadIdleTimeout time.Duration
+	// PingTimeout closes the connection when the ping response does not
+	// arrive in time, 15 seconds by default.
+	PingTimeout time.Duration
+}
+
+// ErrHTTP2Proxy is returned for a request sent with forced HTTP/2 or h2c
+// that has a proxy, those connections are dialed to the server directly.
+var ErrHTTP2Proxy = errors.New("shttp: forced HTTP/2 and h2c requests can not use a proxy")
+
+// WithHTTP2 enables HTTP/2 over TLS, negotiated through ALPN with a fallback
+// to HTTP/1.1 unless Force is set. It also enables the protocol choice of
+// Request.ProtocolVersion.
+func WithHTTP2(conf HTTP2Config) Option {
+	return func(c *Client) {
+		c.HTTP2(conf)
+	}
+}
+
+// HTTP2 enables HTTP/2 on the client transport, see WithHTTP2.
+//
+// Deprecated: use WithHTTP2, see Client.
+func (c *Client) HTTP2(conf HTTP2Config) {
+	if c.built {
+		return
+	}
+	t := c.transport()
+	t.ForceAttemptHTTP2 = true
+	rt := &http2RoundTripper{
+		t1:    t,
+		force: conf.Force,
+		h2c:   conf.H2C,
+	}
+	if c.h2 == nil || c.h2Base != t {
+		h2, err := http2.ConfigureTransports(t)
+		if err != nil {
+			// the transport was configured outside of the client
+			h2 = &http2.Transport{}
+		}
+		c.h2, c.h2Base = h2, t
+	}
+	c.h2.ReadIdleTimeout = conf.ReadIdleTimeout
+	c.h2.PingTimeout = conf.PingTimeout
+	rt.forced = &http2.Transport{
+		DialTLSContext:  rt.dialTLS,
+		ReadIdleTimeout: conf.ReadIdleTimeout,
+		PingTimeout:     conf.PingTimeout,
+	}
+	rt.cleartext = &http2.Transport{
+		AllowHTTP:       true,
+		DialTLSContext:  rt.dialCleartext,
+		ReadIdleTimeout: conf.ReadIdleTimeout,
+		PingTimeout:     conf.PingTimeout,
+	}
+	// below a proxy pool, which must see the requests before their proxy
+	// is resolved
+	if pool, ok := c.c.Transport.(*proxyPoolRoundTripper); ok {
+		pool.next = rt
+	} else {
+		c.c.Transport = rt
+	}
+}
+
+type protoKey struct{}
+
+// checkProtocol fails a request for a protocol the client can not send.
+func (c *Client) checkProtocol(req *http.Request) error {
+	switch major, _ := req.Context().Value(protoKey{}).(int); major {
+	case 0, 1:
+		return nil
+	case 2:
+		if c.h2 == nil {
+			return errors.New("shttp: HTTP/2 requested on a client without WithHTTP2")
+		}
+		return nil
+	default:
+		return errors.New("shttp: unsupported protocol version " + req.Proto)
+	}
+}
+
+// http2RoundTripper sends requests over dedicated HTTP/2 transports when
+// HTTP/2 is forced, h2c is enabled or the request asks for HTTP/2, over an
+// HTTP/1.1 only transport when the request asks for HTTP/1, and to the base
+// transport otherwise. All dial through the base transport so its dial
+// settings still apply.
+type http2RoundTripper struct {
+	t1        *http.Transport
+	forced    *http2.Transport
+	cleartext *http2.Transport
+	force     bool
+	h2c       bool
+
+	h1Mu sync.Mutex
+	h1   *http.Transport
+}
+
+func (rt *http2RoundTripper) unwrap() http.RoundTripper {
+	return rt.t1
+}
+
+func (rt *http2RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	proto, _ := req.Context().Value(protoKey{}).(int)
+	https := req.URL.Scheme == "https"
+	switch {
+	case proto == 1:
+		return rt.http1().RoundTrip(req)
+	case https && (proto == 2 || rt.force):
+		return rt.roundTripH2(rt.forced, req)
+	case !https && (proto == 2 || rt.h2c):
+		return rt.roundTripH2(rt.cleartext, req)
+	}
+	return rt.t1.RoundTrip(req)
+}
+
+// roundTripH2 fails the requests that have a proxy instead of sending them
+// to the server directly.
+func (rt *http2RoundTripper) roundTripH2(t *http2.Transport, req *http.Request) (*http.Response, error) {
+	if rt.t1.Proxy != nil {
+		proxy, err := rt.t1.Proxy(req)
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			return nil, ErrHTTP2Proxy
+		}
+	}
+	return t.RoundTrip(req)
+}
+
+// http1 returns a copy of the base transport that does not negotiate h2.
+func (rt *http2RoundTripper) http1() *http.Transport {
+	rt.h1Mu.Lock()
+	defer rt.h1Mu.Unlock()
+	if rt.h1 == nil {
+		t := rt.t1.Clone()
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if t.TLSClientConfig != nil {
+			t.TLSClientConfig.NextProtos = nil
+		}
+		rt.h1 = t
+	}
+	return rt.h1
+}
+
+func (rt *http2RoundTripper) CloseIdleConnections() {
+	rt.t1.CloseIdleConnections()
+	rt.forced.CloseIdleConnections()
+	rt.cleartext.CloseIdleConnections()
+	rt.h1Mu.Lock()
+	if rt.h1 != nil {
+		rt.h1.CloseIdleConnections()
+	}
+	rt.h1Mu.Unlock()
+}
+
+func (rt *http2RoundTripper) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if rt.t1.DialContext != nil {
+		return rt.t1.DialContext(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+func (rt *http2RoundTripper) dialCleartext(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+	return rt.dial(ctx, network, addr)
+}
+
+func (rt *http2RoundTripper) dialTLS(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+	conn, err := rt.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{}
+	if rt.t1.TLSClientConfig != nil {
+		cfg = rt.t1.TLSClientConfig.Clone()
+	}
+	cfg.NextProtos = []string{http2.NextProtoTLS}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol != http2.NextProtoTLS {
+		tlsConn.Close()
+		return nil, errors.New("http2: server did not negotiate h2")
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,127 @@
+package shttp_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func Test_Client_HTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+
+	tlsSrv := httptest.NewUnstartedServer(handler)
+	tlsSrv.EnableHTTP2 = true
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+
+	h2cSrv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cSrv.Close()
+
+	for name, conf := range map[string]shttp.HTTP2Config{
+		"alpn":  {},
+		"force": {Force: true},
+	} {
+		resp, err := shttp.New(shttp.WithRootCA(serverPEM(tlsSrv)), shttp.WithHTTP2(conf)).Get(tlsSrv.URL)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if body, _ := resp.String(); resp.Proto() != "HTTP/2.0" || body != "HTTP/2.0" {
+			t.Fatalf("%s: negotiated %s, server saw %s", name, resp.Proto(), body)
+		}
+	}
+
+	resp, err := shttp.New(shttp.WithHTTP2(shttp.HTTP2Config{H2C: true})).Get(h2cSrv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Proto() != "HTTP/2.0" {
+		t.Fatalf("h2c negotiated %s", resp.Proto())
+	}
+}
+
+func Test_Client_HTTP2_Proxy(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	proxied := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+	p, _ := url.Parse(proxy.URL)
+
+	pool, err := shttp.NewProxyPool([]string{proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, opt := range map[string]shttp.Option{"static": shttp.WithProxyUrl(p), "pool": shttp.WithProxyPool(pool)} {
+		client := shttp.New(shttp.WithRootCA(serverPEM(srv)), shttp.WithHTTP2(shttp.HTTP2Config{Force: true}), opt)
+		if _, err := client.Get(srv.URL); !errors.Is(err, shttp.ErrHTTP2Proxy) {
+			t.Fatalf("%s: expected ErrHTTP2Proxy, got %v", name, err)
+		}
+	}
+	client := shttp.New(shttp.WithRootCA(serverPEM(srv)), shttp.WithHTTP2(shttp.HTTP2Config{Force: true}))
+	if _, err := client.Get(srv.URL, shttp.ProxyHandler(p)); !errors.Is(err, shttp.ErrHTTP2Proxy) {
+		t.Fatalf("request proxy: expected ErrHTTP2Proxy, got %v", err)
+	}
+	if proxied {
+		t.Fatal("request sent to the proxy")
+	}
+	if s := pool.Stats()[0]; s.Failures != 0 || s.Ejected {
+		t.Fatalf("unused proxy counted as failed: %+v", s)
+	}
+}
+
+func Test_Request_ProtocolVersion(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	tlsSrv := httptest.NewUnstartedServer(handler)
+	tlsSrv.EnableHTTP2 = true
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+	h2cSrv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cSrv.Close()
+
+	client := shttp.New(shttp.WithRootCA(serverPEM(tlsSrv)), shttp.WithHTTP2(shttp.HTTP2Config{}))
+	for _, tc := range []struct {
+		url, proto string
+		major      int
+		want       string
+	}{
+		{tlsSrv.URL, "", 0, "HTTP/2.0"},
+		{tlsSrv.URL, "HTTP/1.1", 1, "HTTP/1.1"},
+		{tlsSrv.URL, "HTTP/2.0", 2, "HTTP/2.0"},
+		{h2cSrv.URL, "", 0, "HTTP/1.1"},
+		{h2cSrv.URL, "HTTP/2.0", 2, "HTTP/2.0"},
+	} {
+		body, err := client.GetToString(tc.url, func(c *shttp.Client, req *shttp.Request) {
+			req.ProtocolVersion(tc.proto, tc.major, 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != tc.want {
+			t.Fatalf("%s %q: server saw %s, expected %s", tc.url, tc.proto, body, tc.want)
+		}
+	}
+
+	_, err := shttp.New().Get(h2cSrv.URL, func(c *shttp.Client, req *shttp.Request) {
+		req.ProtocolVersion("HTTP/2.0", 2, 0)
+	})
+	if err == nil {
+		t.Fatal("HTTP/2 request sent by a client without HTTP/2")
+	}
+}
@@ -0,0 +1,233 @@
+package shttp
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONResult is the value found by Response.JSONPath.
+type JSONResult struct {
+	value  interface{}
+	exists bool
+	err    error
+}
+
+// JSONPath returns the value at path of the JSON body. The body is decoded
+// once and reused by the following queries. A path is a list of keys
+// separated by dots:
+//
+//	data.user.name     object keys, escape a dot in a key as \.
+//	data.items.0.id    array index
+//	data.items.#       array length
+//	data.items.#.id    the id of every item, as an array
+//	data.*.id          the id of every value of an object or array
+func (r *Response) JSONPath(path string) JSONResult {
+	if !r.jsonParsed {
+		r.json, r.jsonErr = r.decodeJSON()
+		r.jsonParsed = true
+	}
+	if r.jsonErr != nil {
+		return JSONResult{err: r.jsonErr}
+	}
+	v, ok := jsonQuery(r.json, splitJSONPath(path))
+	return JSONResult{value: v, exists: ok}
+}
+
+func (r *Response) decodeJSON() (interface{}, error) {
+	text, err := r.Text()
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func splitJSONPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	keys := make([]string, 0)
+	var key strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+		case path[i] == '.':
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(path[i])
+		}
+	}
+	return append(keys, key.String())
+}
+
+func jsonQuery(v interface{}, keys []string) (interface{}, bool) {
+	if len(keys) == 0 {
+		return v, true
+	}
+	key, rest := keys[0], keys[1:]
+	switch key {
+	case "#":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		if len(rest) == 0 {
+			return json.Number(strconv.Itoa(len(arr))), true
+		}
+		return jsonCollect(arr, rest), true
+	case "*":
+		switch t := v.(type) {
+		case []interface{}:
+			return jsonCollect(t, rest), true
+		case map[string]interface{}:
+			names := make([]string, 0, len(t))
+			for name := range t {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			values := make([]interface{}, len(names))
+			for i, name := range names {
+				values[i] = t[name]
+			}
+			return jsonCollect(values, rest), true
+		}
+		return nil, false
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		child, ok := t[key]
+		if !ok {
+			return nil, false
+		}
+		return jsonQuery(child, rest)
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(t) {
+			return nil, false
+		}
+		return jsonQuery(t[i], rest)
+	}
+	return nil, false
+}
+
+// jsonCollect queries the rest of the path on every element, skipping the
+// elements without the value.
+func jsonCollect(elems []interface{}, rest []string) []interface{} {
+	values := make([]interface{}, 0, len(elems))
+	for _, e := range elems {
+		if v, ok := jsonQuery(e, rest); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Err returns the error of decoding the body.
+func (r JSONResult) Err() error {
+	return r.err
+}
+
+// Exists reports whether the path was found, also for a null value.
+func (r JSONResult) Exists() bool {
+	return r.exists
+}
+
+// Value returns the decoded value: nil, bool, json.Number, string,
+// []interface{} or map[string]interface{}.
+func (r JSONResult) Value() interface{} {
+	return r.value
+}
+
+// String returns a string, the text of a number or bool, the JSON of an
+// object or array and "" for null or a missing value.
+func (r JSONResult) String() string {
+	switch v := r.value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	bs, _ := json.Marshal(r.value)
+	return string(bs)
+}
+
+// Int returns a number, or a string holding one, truncated to an integer,
+// 1 for true and 0 otherwise.
+func (r JSONResult) Int() int64 {
+	switch v := r.value.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case json.Number, string:
+		s := r.String()
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		f, _ := strconv.ParseFloat(s, 64)
+		return int64(f)
+	}
+	return 0
+}
+
+// Float returns a number, or a string holding one, 1 for true and 0
+// otherwise.
+func (r JSONResult) Float() float64 {
+	switch v := r.value.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case json.Number, string:
+		f, _ := strconv.ParseFloat(r.String(), 64)
+		return f
+	}
+	return 0
+}
+
+// Bool returns a bool, true for a non-zero number and for a string parsed
+// as true by strconv.ParseBool.
+func (r JSONResult) Bool() bool {
+	switch v := r.value.(type) {
+	case bool:
+		return v
+	case json.Number:
+		return r.Float() != 0
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// Array returns the elements of an array, a single element for another
+// value and nil for null or a missing value.
+func (r JSONResult) Array() []JSONResult {
+	switch v := r.value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		results := make([]JSONResult, len(v))
+		for i, e := range v {
+			results[i] = JSONResult{value: e, exists: true}
+		}
+		return results
+	}
+	return []JSONResult{r}
+}
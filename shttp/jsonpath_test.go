@@ -0,0 +1,40 @@
+package shttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Response_JSONPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"total":"3","ok":true,"id.v2":9007199254740993,
+			"items":[{"id":1,"price":1.5},{"id":2,"price":2.5},{"name":"x"}]}}`))
+	}))
+	defer srv.Close()
+
+	resp, err := shttp.New().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.JSONPath("data.total").Int() != 3 || !resp.JSONPath("data.ok").Bool() {
+		t.Fatal("unexpected scalar values")
+	}
+	if id := resp.JSONPath(`data.id\.v2`).Int(); id != 9007199254740993 {
+		t.Fatalf("unexpected escaped key value %d", id)
+	}
+	if resp.JSONPath("data.items.1.price").Float() != 2.5 || resp.JSONPath("data.items.#").Int() != 3 {
+		t.Fatal("unexpected array values")
+	}
+	if ids := resp.JSONPath("data.items.#.id").String(); ids != "[1,2]" {
+		t.Fatalf("unexpected ids %s", ids)
+	}
+	if names := resp.JSONPath("data.items.*.name").Array(); len(names) != 1 || names[0].String() != "x" {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if resp.JSONPath("data.missing").Exists() || resp.JSONPath("data.items.9").Exists() {
+		t.Fatal("missing path exists")
+	}
+}
@@ -0,0 +1,230 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+const jsonRPCVersion = "2.0"
+
+// JSONRPCClient sends JSON-RPC 2.0 calls over a Client, so its options and
+// middlewares apply.
+type JSONRPCClient struct {
+	c        *Client
+	url      string
+	handlers []RequestHandler
+
+	id uint64
+}
+
+// JSONRPCError is the error object of a JSON-RPC response.
+type JSONRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("jsonrpc: %d %s", e.Code, e.Message)
+}
+
+// DecodeData decodes the data member of the error into v.
+func (e *JSONRPCError) DecodeData(v interface{}) error {
+	if len(e.Data) == 0 {
+		return errors.New("jsonrpc: error has no data")
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// JSONRPCCall is a call of a batch. Result is decoded from the response of
+// the call and Error is its error, a *JSONRPCError when the server replied
+// with one. A Notify call has no response.
+type JSONRPCCall struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Notify bool
+	Error  error
+}
+
+type jsonRPCRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *uint64     `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *JSONRPCError   `json:"error"`
+}
+
+// id returns the numeric ID of the response, false for a null or foreign
+// ID.
+func (r *jsonRPCResponse) id() (uint64, bool) {
+	id, err := strconv.ParseUint(string(bytes.Trim(r.ID, `"`)), 10, 64)
+	return id, err == nil
+}
+
+// checkVersion fails for a response that is not JSON-RPC 2.0.
+func (r *jsonRPCResponse) checkVersion() error {
+	if r.JSONRPC != jsonRPCVersion {
+		return fmt.Errorf("jsonrpc: response version %q", r.JSONRPC)
+	}
+	return nil
+}
+
+// JSONRPC returns a JSON-RPC 2.0 client of the endpoint url.
+func (c *Client) JSONRPC(url string, handlers ...RequestHandler) *JSONRPCClient {
+	return &JSONRPCClient{
+		c:        c,
+		url:      url,
+		handlers: handlers,
+	}
+}
+
+// Call calls method with params, a struct, map or slice, and decodes the
+// result into result, which may be nil. A response that is not JSON-RPC 2.0
+// or answers another ID is an error.
+func (j *JSONRPCClient) Call(ctx context.Context, method string, params interface{}, result interface{}, handlers ...RequestHandler) error {
+	id := atomic.AddUint64(&j.id, 1)
+	bs, err := j.send(ctx, jsonRPCRequest{JSONRPC: jsonRPCVersion, ID: &id, Method: method, Params: params}, handlers)
+	if err != nil {
+		return err
+	}
+	var resp jsonRPCResponse
+	if err := json.Unmarshal(bs, &resp); err != nil {
+		return err
+	}
+	if err := resp.checkVersion(); err != nil {
+		return err
+	}
+	// the ID of an error is null when the server could not read the request
+	if resp.Error != nil && bytes.Equal(bytes.TrimSpace(resp.ID), []byte("null")) {
+		return resp.Error
+	}
+	if got, ok := resp.id(); !ok || got != id {
+		return fmt.Errorf("jsonrpc: response id %s for request id %d", resp.ID, id)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	return decodeJSONRPCResult(resp.Result, result)
+}
+
+// Notify sends a notification, a call without a response.
+func (j *JSONRPCClient) Notify(ctx context.Context, method string, params interface{}, handlers ...RequestHandler) error {
+	_, err := j.send(ctx, jsonRPCRequest{JSONRPC: jsonRPCVersion, Method: method, Params: params}, handlers)
+	return err
+}
+
+// Batch sends the calls in one request and sets the Result or Error of each
+// call from the response of the same ID. The returned error is the failure
+// of the whole batch.
+func (j *JSONRPCClient) Batch(ctx context.Context, calls []*JSONRPCCall, handlers ...RequestHandler) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	reqs := make([]jsonRPCRequest, len(calls))
+	byID := make(map[uint64]*JSONRPCCall, len(calls))
+	for i, call := range calls {
+		reqs[i] = jsonRPCRequest{JSONRPC: jsonRPCVersion, Method: call.Method, Params: call.Params}
+		if !call.Notify {
+			id := atomic.AddUint64(&j.id, 1)
+			reqs[i].ID = &id
+			byID[id] = call
+		}
+	}
+	bs, err := j.send(ctx, reqs, handlers)
+	if err != nil {
+		return err
+	}
+	if len(byID) == 0 {
+		return nil
+	}
+	var resps []jsonRPCResponse
+	if err := json.Unmarshal(bs, &resps); err != nil {
+		// a server that rejects the whole batch replies with a single error
+		var resp jsonRPCResponse
+		if json.Unmarshal(bs, &resp) == nil && resp.Error != nil {
+			return resp.Error
+		}
+		return err
+	}
+	for _, resp := range resps {
+		id, ok := resp.id()
+		if !ok {
+			continue
+		}
+		call, ok := byID[id]
+		if !ok {
+			continue
+		}
+		delete(byID, id)
+		if err := resp.checkVersion(); err != nil {
+			call.Error = err
+			continue
+		}
+		if resp.Error != nil {
+			call.Error = resp.Error
+			continue
+		}
+		call.Error = decodeJSONRPCResult(resp.Result, call.Result)
+	}
+	for id, call := range byID {
+		call.Error = fmt.Errorf("jsonrpc: no response for id %d", id)
+	}
+	return nil
+}
+
+func decodeJSONRPCResult(raw json.RawMessage, result interface{}) error {
+	if result == nil || len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, result)
+}
+
+// send posts body and returns the response body, empty for notifications.
+func (j *JSONRPCClient) send(ctx context.Context, body interface{}, handlers []RequestHandler) ([]byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, POST.String(), j.c.resolveURL(j.url), nil)
+	if err != nil {
+		return nil, err
+	}
+	var bodyErr error
+	all := make([]RequestHandler, 0, len(j.handlers)+len(handlers)+1)
+	all = append(all, func(c *Client, req *Request) {
+		req.Header(httpHeaderAccept, httpHeaderContentTypeJson)
+		bodyErr = req.BodyJSON(body)
+	})
+	all = append(all, j.handlers...)
+	all = append(all, handlers...)
+	req := j.c.handlerRequest(httpReq, all...)
+	if bodyErr != nil {
+		return nil, bodyErr
+	}
+	resp, err := j.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := resp.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if resp.resp.StatusCode >= 300 {
+		// JSON-RPC over HTTP may carry the error object with an error status
+		var rpc jsonRPCResponse
+		if json.Unmarshal(bs, &rpc) == nil && rpc.Error != nil {
+			return nil, rpc.Error
+		}
+		return nil, fmt.Errorf("jsonrpc: response status %s", resp.resp.Status)
+	}
+	return bs, nil
+}
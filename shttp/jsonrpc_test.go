@@ -0,0 +1,181 @@
+package shttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+type jsonRPCBody struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  []int           `json:"params"`
+}
+
+// jsonRPCReply answers add with the sum of the params and fail with an
+// error, notifications get no response.
+func jsonRPCReply(call jsonRPCBody) interface{} {
+	if call.ID == nil {
+		return nil
+	}
+	switch call.Method {
+	case "add":
+		sum := 0
+		for _, p := range call.Params {
+			sum += p
+		}
+		return map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "result": sum}
+	}
+	return map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "error": map[string]interface{}{
+		"code": -32601, "message": "Method not found", "data": map[string]string{"method": call.Method},
+	}}
+}
+
+func jsonRPCServer(t *testing.T, notified *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			t.Error(err)
+			return
+		}
+		if raw[0] != '[' {
+			var call jsonRPCBody
+			json.Unmarshal(raw, &call)
+			if call.JSONRPC != "2.0" {
+				t.Errorf("unexpected version %q", call.JSONRPC)
+			}
+			reply := jsonRPCReply(call)
+			if reply == nil {
+				atomic.AddInt32(notified, 1)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			json.NewEncoder(w).Encode(reply)
+			return
+		}
+		var calls []jsonRPCBody
+		json.Unmarshal(raw, &calls)
+		replies := make([]interface{}, 0, len(calls))
+		// reply in reverse order, the client correlates by id
+		for i := len(calls) - 1; i >= 0; i-- {
+			if reply := jsonRPCReply(calls[i]); reply != nil {
+				replies = append(replies, reply)
+			} else {
+				atomic.AddInt32(notified, 1)
+			}
+		}
+		json.NewEncoder(w).Encode(replies)
+	}))
+}
+
+func Test_JSONRPC_Call(t *testing.T) {
+	var notified int32
+	srv := jsonRPCServer(t, &notified)
+	defer srv.Close()
+
+	rpc := shttp.New().JSONRPC(srv.URL)
+	var sum int
+	if err := rpc.Call(context.Background(), "add", []int{1, 2, 3}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 6 {
+		t.Fatalf("unexpected result %d", sum)
+	}
+
+	err := rpc.Call(context.Background(), "missing", nil, nil)
+	rpcErr, ok := err.(*shttp.JSONRPCError)
+	if !ok || rpcErr.Code != -32601 || rpcErr.Message != "Method not found" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var data struct {
+		Method string `json:"method"`
+	}
+	if err := rpcErr.DecodeData(&data); err != nil || data.Method != "missing" {
+		t.Fatalf("unexpected error data %+v %v", data, err)
+	}
+
+	if err := rpc.Notify(context.Background(), "log", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&notified) != 1 {
+		t.Fatal("notification not received")
+	}
+}
+
+func Test_JSONRPC_Batch(t *testing.T) {
+	var notified int32
+	srv := jsonRPCServer(t, &notified)
+	defer srv.Close()
+
+	var a, b int
+	calls := []*shttp.JSONRPCCall{
+		{Method: "add", Params: []int{1, 2}, Result: &a},
+		{Method: "log", Notify: true},
+		{Method: "add", Params: []int{10, 20}, Result: &b},
+		{Method: "missing"},
+	}
+	if err := shttp.New().JSONRPC(srv.URL).Batch(context.Background(), calls); err != nil {
+		t.Fatal(err)
+	}
+	if a != 3 || b != 30 || calls[0].Error != nil || calls[2].Error != nil {
+		t.Fatalf("unexpected results %d %d %v %v", a, b, calls[0].Error, calls[2].Error)
+	}
+	if calls[1].Error != nil || atomic.LoadInt32(&notified) != 1 {
+		t.Fatalf("unexpected notification %v", calls[1].Error)
+	}
+	if rpcErr, ok := calls[3].Error.(*shttp.JSONRPCError); !ok || rpcErr.Code != -32601 {
+		t.Fatalf("unexpected error %v", calls[3].Error)
+	}
+}
+
+func Test_JSONRPC_Status(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"Parse error"}}`)
+	}))
+	defer srv.Close()
+
+	err := shttp.New().JSONRPC(srv.URL).Call(context.Background(), "add", nil, nil)
+	if rpcErr, ok := err.(*shttp.JSONRPCError); !ok || rpcErr.Code != -32700 {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func Test_JSONRPC_ResponseCheck(t *testing.T) {
+	var reply string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, reply)
+	}))
+	defer srv.Close()
+
+	rpc := shttp.New().JSONRPC(srv.URL)
+	for _, tc := range []struct {
+		reply string
+		ok    bool
+	}{
+		{`{"jsonrpc":"2.0","id":1,"result":1}`, true},
+		{`{"jsonrpc":"2.0","id":1,"result":1}`, false},
+		{`{"jsonrpc":"1.0","id":3,"result":1}`, false},
+		{`{"id":4,"result":1}`, false},
+		{`{"jsonrpc":"2.0","result":1}`, false},
+	} {
+		reply = tc.reply
+		err := rpc.Call(context.Background(), "add", nil, nil)
+		if (err == nil) != tc.ok {
+			t.Fatalf("reply %s: unexpected error %v", tc.reply, err)
+		}
+	}
+
+	reply = `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"Parse error"}}`
+	err := rpc.Call(context.Background(), "add", nil, nil)
+	if rpcErr, ok := err.(*shttp.JSONRPCError); !ok || rpcErr.Code != -32700 {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package shttp
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// BodyLimits bound the size of response bodies, a zero value disables the
+// limit.
+type BodyLimits struct {
+	// MaxBytes limits the body as received, it is enforced while reading so
+	// it also applies to Response().Body.
+	MaxBytes int64
+	// MaxDecompressedBytes limits the body after the gzip decompression.
+	MaxDecompressedBytes int64
+	// MaxRatio limits the decompressed size of a gzip body to this multiple
+	// of its compressed size, e.g. 100.
+	MaxRatio float64
+	// A client with MaxDecompressedBytes or MaxRatio asks for gzip itself
+	// instead of the transport and counts the compressed bytes, unless the
+	// request has an Accept-Encoding or the transport DisableCompression.
+}
+
+// BodyTooLargeError is returned when a response body exceeds a limit of
+// BodyLimits.
+type BodyTooLargeError struct {
+	// Limit is the exceeded size in bytes.
+	Limit int64
+	// Decompressed reports that the decompressed body exceeded the limit.
+	Decompressed bool
+	// Ratio is the exceeded MaxRatio, zero for the size limits.
+	Ratio float64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	switch {
+	case e.Ratio > 0:
+		return fmt.Sprintf("decompressed response body exceeds %d bytes, %g times the compressed size", e.Limit, e.Ratio)
+	case e.Decompressed:
+		return fmt.Sprintf("decompressed response body exceeds %d bytes", e.Limit)
+	}
+	return fmt.Sprintf("response body exceeds %d bytes", e.Limit)
+}
+
+// WithBodyLimits bounds the size of the response bodies, see BodyLimits.
+func WithBodyLimits(limits BodyLimits) Option {
+	return func(c *Client) {
+		c.bodyLimits = limits
+	}
+}
+
+// BodyLimits overrides the body limits of the client for this request, zero
+// fields keep the client value.
+func (r *Request) BodyLimits(limits BodyLimits) {
+	r.bodyLimits = limits
+}
+
+// MaxBodySize bounds the response body of this request, see
+// BodyLimits.MaxBytes.
+func (r *Request) MaxBodySize(n int64) {
+	r.bodyLimits.MaxBytes = n
+}
+
+// requestBodyLimits merges the request overrides into the client limits.
+func (c *Client) requestBodyLimits(req *Request) BodyLimits {
+	l := c.bodyLimits
+	o := req.bodyLimits
+	if o.MaxBytes > 0 {
+		l.MaxBytes = o.MaxBytes
+	}
+	if o.MaxDecompressedBytes > 0 {
+		l.MaxDecompressedBytes = o.MaxDecompressedBytes
+	}
+	if o.MaxRatio > 0 {
+		l.MaxRatio = o.MaxRatio
+	}
+	return l
+}
+
+// limitResponse bounds the body of httpResp as it is read.
+func limitResponse(httpResp *http.Response, l BodyLimits) {
+	err := &BodyTooLargeError{Limit: l.MaxBytes}
+	if httpResp.Uncompressed && l.MaxDecompressedBytes > 0 && (l.MaxBytes <= 0 || l.MaxDecompressedBytes < l.MaxBytes) {
+		err = &BodyTooLargeError{Limit: l.MaxDecompressedBytes, Decompressed: true}
+	}
+	if err.Limit <= 0 {
+		return
+	}
+	httpResp.Body = &limitedBody{rc: httpResp.Body, remaining: err.Limit, err: err}
+}
+
+// limitedBody fails the read of the bytes after its limit.
+type limitedBody struct {
+	rc        io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// a body of exactly the limit ends here
+		var probe [1]byte
+		n, err := b.rc.Read(probe[:])
+		if n > 0 {
+			return 0, b.err
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.rc.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.rc.Close()
+}
+
+// decompressedLimit returns the decompressed size limit of a body of
+// compressed bytes and its error, a zero limit when there is none.
+func decompressedLimit(compressed int64, l BodyLimits) (int64, *BodyTooLargeError) {
+	limit := l.MaxDecompressedBytes
+	err := &BodyTooLargeError{Limit: limit, Decompressed: true}
+	if l.MaxRatio > 0 {
+		if byRatio := int64(l.MaxRatio * float64(compressed)); limit <= 0 || byRatio < limit {
+			limit = byRatio
+			err = &BodyTooLargeError{Limit: limit, Decompressed: true, Ratio: l.MaxRatio}
+		}
+	}
+	return limit, err
+}
+
+// readDecompressed reads r within the decompressed size and ratio limits of
+// a body of compressed bytes.
+func readDecompressed(r io.Reader, compressed int, l BodyLimits) ([]byte, error) {
+	limit, err := decompressedLimit(int64(compressed), l)
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	bs, rerr := io.ReadAll(io.LimitReader(r, limit+1))
+	if rerr != nil {
+		return nil, rerr
+	}
+	if int64(len(bs)) > limit {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// acceptGzip asks for a gzip response on behalf of the transport when the
+// decompression limits need the compressed size, see BodyLimits. It reports
+// whether the response must be decompressed by decompressResponse.
+func (c *Client) acceptGzip(httpReq *http.Request, l BodyLimits) bool {
+	if l.MaxDecompressedBytes <= 0 && l.MaxRatio <= 0 {
+		return false
+	}
+	// the cases where the transport does not ask for gzip either
+	if httpReq.Method == http.MethodHead || httpReq.Header.Get("Accept-Encoding") != "" || httpReq.Header.Get("Range") != "" {
+		return false
+	}
+	if t := c.baseTransport(); t == nil || t.DisableCompression {
+		return false
+	}
+	httpReq.Header.Set("Accept-Encoding", "gzip")
+	return true
+}
+
+// decompressResponse decodes a gzip body like the transport does, within
+// the decompressed size and ratio limits.
+func decompressResponse(httpResp *http.Response, l BodyLimits) {
+	if !strings.EqualFold(httpResp.Header.Get(httpHeaderContentEncoding), "gzip") || httpResp.Body == http.NoBody {
+		return
+	}
+	httpResp.Body = &gzipBody{rc: httpResp.Body, wire: &countingReader{r: httpResp.Body}, limits: l}
+	httpResp.Header.Del(httpHeaderContentEncoding)
+	httpResp.Header.Del("Content-Length")
+	httpResp.ContentLength = -1
+	httpResp.Uncompressed = true
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// gzipBody decompresses rc and fails once the decompressed bytes exceed the
+// limits for the compressed bytes read so far.
+type gzipBody struct {
+	rc     io.ReadCloser
+	wire   *countingReader
+	zr     *gzip.Reader
+	n      int64
+	limits BodyLimits
+	err    error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.zr == nil {
+		if b.zr, b.err = gzip.NewReader(b.wire); b.err != nil {
+			return 0, b.err
+		}
+	}
+	n, err := b.zr.Read(p)
+	b.n += int64(n)
+	if limit, tooLarge := decompressedLimit(b.wire.n, b.limits); limit > 0 && b.n > limit {
+		b.err = tooLarge
+		return 0, b.err
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *gzipBody) Close() error {
+	return b.rc.Close()
+}
@@ -0,0 +1,100 @@
+package shttp_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Response_BodyLimits(t *testing.T) {
+	var bomb bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bomb" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(bomb.Bytes())
+			return
+		}
+		w.Write(make([]byte, 1024))
+	}))
+	defer srv.Close()
+
+	client := shttp.New(shttp.WithBodyLimits(shttp.BodyLimits{MaxBytes: 1024, MaxRatio: 10}))
+	if body, err := client.GetToBytes(srv.URL); err != nil || len(body) != 1024 {
+		t.Fatalf("body of the limit size rejected: %v", err)
+	}
+	_, err := client.GetToBytes(srv.URL, func(c *shttp.Client, req *shttp.Request) {
+		req.MaxBodySize(512)
+	})
+	var tooLarge *shttp.BodyTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != 512 || tooLarge.Decompressed {
+		t.Fatalf("expected a body size error, got %v", err)
+	}
+
+	gzipped := func(c *shttp.Client, req *shttp.Request) {
+		req.Header("Accept-Encoding", "gzip")
+		req.MaxBodySize(1 << 20)
+	}
+	_, err = client.GetToBytes(srv.URL+"/bomb", gzipped)
+	if !errors.As(err, &tooLarge) || tooLarge.Ratio != 10 {
+		t.Fatalf("expected a ratio error, got %v", err)
+	}
+	body, err := shttp.New().GetToBytes(srv.URL+"/bomb", gzipped)
+	if err != nil || len(body) != 1<<20 {
+		t.Fatalf("unlimited decompression failed: %v", err)
+	}
+}
+
+func Test_Response_BodyLimits_TransparentGzip(t *testing.T) {
+	var bomb, text bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+	zw = gzip.NewWriter(&text)
+	zw.Write([]byte("hello, compressed world"))
+	zw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte("identity"))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.URL.Path == "/bomb" {
+			w.Write(bomb.Bytes())
+			return
+		}
+		w.Write(text.Bytes())
+	}))
+	defer srv.Close()
+
+	// no Accept-Encoding on the request, the gzip is negotiated by shttp
+	client := shttp.New(shttp.WithBodyLimits(shttp.BodyLimits{MaxRatio: 10}))
+	_, err := client.GetToBytes(srv.URL + "/bomb")
+	var tooLarge *shttp.BodyTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Ratio != 10 {
+		t.Fatalf("expected a ratio error, got %v", err)
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := resp.String(); err != nil || body != "hello, compressed world" {
+		t.Fatalf("unexpected body %q %v", body, err)
+	}
+	if resp.Response().Header.Get("Content-Encoding") != "" || !resp.Response().Uncompressed {
+		t.Fatal("decompressed response keeps its Content-Encoding")
+	}
+
+	client = shttp.New(shttp.WithBodyLimits(shttp.BodyLimits{MaxDecompressedBytes: 1 << 10}))
+	_, err = client.GetToBytes(srv.URL + "/bomb")
+	if !errors.As(err, &tooLarge) || !tooLarge.Decompressed || tooLarge.Limit != 1<<10 {
+		t.Fatalf("expected a decompressed size error, got %v", err)
+	}
+}
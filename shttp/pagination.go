@@ -0,0 +1,355 @@
+package shttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PageStrategy decides how the request for the following page is built.
+// Strategies may keep state and must not be shared between iterators.
+type PageStrategy interface {
+	// Next inspects the current page and returns the handler that turns the
+	// base request into the request for the following page. ok is false when
+	// there are no more pages.
+	Next(resp *Response, items int) (next RequestHandler, ok bool, err error)
+}
+
+// FirstPageStrategy is a PageStrategy that also builds the request of the
+// first page, e.g. to send the first page number.
+type FirstPageStrategy interface {
+	PageStrategy
+	// First returns the handler applied to the request of the first page.
+	First() RequestHandler
+}
+
+type PageStrategyFunc func(resp *Response, items int) (RequestHandler, bool, error)
+
+func (f PageStrategyFunc) Next(resp *Response, items int) (RequestHandler, bool, error) {
+	return f(resp, items)
+}
+
+// Pages iterates over the items of a paginated list endpoint.
+//
+//	pages := client.Paginate(ctx, url, shttp.LinkPagination()).ItemsPath("data")
+//	for pages.Next() {
+//		var item Item
+//		if err := pages.Decode(&item); err != nil { ... }
+//	}
+//	if err := pages.Err(); err != nil { ... }
+type Pages struct {
+	c        *Client
+	ctx      context.Context
+	url      string
+	method   Method
+	handlers []RequestHandler
+	strategy PageStrategy
+
+	itemsPath string
+	maxPages  int
+
+	page  int
+	next  RequestHandler
+	done  bool
+	resp  *Response
+	items []json.RawMessage
+	item  json.RawMessage
+	err   error
+}
+
+// Paginate returns an iterator over the pages of url. The handlers are
+// applied to the request of every page before the strategy's own handler.
+func (c *Client) Paginate(ctx context.Context, url string, strategy PageStrategy, handlers ...RequestHandler) *Pages {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Pages{
+		c:        c,
+		ctx:      ctx,
+		url:      url,
+		method:   GET,
+		handlers: handlers,
+		strategy: strategy,
+	}
+}
+
+// Method sets the HTTP method used for every page, GET by default.
+func (p *Pages) Method(method Method) *Pages {
+	p.method = method
+	return p
+}
+
+// ItemsPath sets the path of the items array in the JSON body, e.g.
+// "data.items", see Response.JSONPath. An empty path means the body itself
+// is the array.
+func (p *Pages) ItemsPath(path string) *Pages {
+	p.itemsPath = path
+	return p
+}
+
+// MaxPages stops the iteration after n pages, zero means no limit.
+func (p *Pages) MaxPages(n int) *Pages {
+	p.maxPages = n
+	return p
+}
+
+// Next advances to the next item, fetching the following page when the
+// current one is exhausted. It returns false when the iteration stops.
+func (p *Pages) Next() bool {
+	for len(p.items) == 0 {
+		if p.err != nil || p.done {
+			return false
+		}
+		p.fetch()
+	}
+	p.item, p.items = p.items[0], p.items[1:]
+	return true
+}
+
+// Item returns the raw JSON of the current item.
+func (p *Pages) Item() json.RawMessage {
+	return p.item
+}
+
+// Decode unmarshals the current item into v.
+func (p *Pages) Decode(v interface{}) error {
+	if p.item == nil {
+		return errors.New("no current item")
+	}
+	return json.Unmarshal(p.item, v)
+}
+
+// Page returns the number of pages fetched so far.
+func (p *Pages) Page() int {
+	return p.page
+}
+
+// Response returns the response of the last fetched page.
+func (p *Pages) Response() *Response {
+	return p.resp
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (p *Pages) Err() error {
+	return p.err
+}
+
+func (p *Pages) fetch() {
+	if p.maxPages > 0 && p.page >= p.maxPages {
+		p.done = true
+		return
+	}
+	if err := p.ctx.Err(); err != nil {
+		p.err = err
+		return
+	}
+	if p.page > 0 && p.next == nil {
+		p.done = true
+		return
+	}
+
+	handlers := make([]RequestHandler, 0, len(p.handlers)+2)
+	handlers = append(handlers, func(c *Client, req *Request) {
+		req.WithContext(p.ctx)
+	})
+	handlers = append(handlers, p.handlers...)
+	if p.next != nil {
+		handlers = append(handlers, p.next)
+	} else if first, ok := p.strategy.(FirstPageStrategy); ok {
+		if h := first.First(); h != nil {
+			handlers = append(handlers, h)
+		}
+	}
+	resp, err := p.c.Request(p.url, p.method, nil, handlers...)
+	if err != nil {
+		p.err = err
+		return
+	}
+	p.page++
+	p.resp = resp
+
+	code := resp.Response().StatusCode
+	if code < 200 || code > 299 {
+		resp.Response().Body.Close()
+		p.err = fmt.Errorf("page %d: unexpected status %s", p.page, resp.Response().Status)
+		return
+	}
+	items, err := jsonItems(resp, p.itemsPath)
+	if err != nil {
+		p.err = fmt.Errorf("page %d: %w", p.page, err)
+		return
+	}
+	p.items = items
+
+	next, ok, err := p.strategy.Next(resp, len(items))
+	if err != nil {
+		p.err = err
+		return
+	}
+	if !ok {
+		p.next = nil
+		p.done = true
+		return
+	}
+	p.next = next
+}
+
+// LinkPagination follows the RFC 5988 `Link: <...>; rel="next"` header.
+func LinkPagination() PageStrategy {
+	return PageStrategyFunc(func(resp *Response, items int) (RequestHandler, bool, error) {
+		next := linkNext(resp.Response().Header)
+		if next == "" {
+			return nil, false, nil
+		}
+		u, err := url.Parse(next)
+		if err != nil {
+			return nil, false, err
+		}
+		if req := resp.Response().Request; req != nil && req.URL != nil {
+			u = req.URL.ResolveReference(u)
+		}
+		return func(c *Client, req *Request) {
+			// the next link carries the full query of the following page
+			req.req.URL = u
+			req.req.Host = u.Host
+			req.queries = url.Values{}
+		}, true, nil
+	})
+}
+
+// CursorPagination reads the cursor at path of the JSON body, see
+// Response.JSONPath, and sends it in the query parameter param. It stops on
+// a missing, null or empty cursor.
+func CursorPagination(path, param string) PageStrategy {
+	return PageStrategyFunc(func(resp *Response, items int) (RequestHandler, bool, error) {
+		res := resp.JSONPath(path)
+		if err := res.Err(); err != nil {
+			return nil, false, err
+		}
+		var cursor string
+		switch t := res.Value().(type) {
+		case string:
+			cursor = t
+		case json.Number:
+			cursor = t.String()
+		case nil:
+		default:
+			return nil, false, fmt.Errorf("cursor %q is not a string or number", path)
+		}
+		if cursor == "" {
+			return nil, false, nil
+		}
+		return func(c *Client, req *Request) {
+			req.Query(param, cursor)
+		}, true, nil
+	})
+}
+
+// PagePagination sends the page number in the query parameter param,
+// starting at first. It stops on an empty page.
+func PagePagination(param string, first int) PageStrategy {
+	page := first
+	return &firstPageStrategy{
+		first: func(c *Client, req *Request) {
+			req.Query(param, strconv.Itoa(first))
+		},
+		next: func(resp *Response, items int) (RequestHandler, bool, error) {
+			if items == 0 {
+				return nil, false, nil
+			}
+			page++
+			n := page
+			return func(c *Client, req *Request) {
+				req.Query(param, strconv.Itoa(n))
+			}, true, nil
+		},
+	}
+}
+
+// OffsetPagination sends the number of items seen so far in the query
+// parameter param, starting at start. It stops on an empty page.
+func OffsetPagination(param string, start int) PageStrategy {
+	offset := start
+	return &firstPageStrategy{
+		first: func(c *Client, req *Request) {
+			req.Query(param, strconv.Itoa(start))
+		},
+		next: func(resp *Response, items int) (RequestHandler, bool, error) {
+			if items == 0 {
+				return nil, false, nil
+			}
+			offset += items
+			n := offset
+			return func(c *Client, req *Request) {
+				req.Query(param, strconv.Itoa(n))
+			}, true, nil
+		},
+	}
+}
+
+type firstPageStrategy struct {
+	first RequestHandler
+	next  PageStrategyFunc
+}
+
+func (s *firstPageStrategy) First() RequestHandler {
+	return s.first
+}
+
+func (s *firstPageStrategy) Next(resp *Response, items int) (RequestHandler, bool, error) {
+	return s.next(resp, items)
+}
+
+func linkNext(h http.Header) string {
+	for _, v := range h.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// jsonItems returns the JSON of the elements of the array at path, none
+// when the path is missing or null.
+func jsonItems(resp *Response, path string) ([]json.RawMessage, error) {
+	res := resp.JSONPath(path)
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+	if res.Value() == nil {
+		return nil, nil
+	}
+	arr, ok := res.Value().([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("items %q: not an array", path)
+	}
+	items := make([]json.RawMessage, 0, len(arr))
+	for _, v := range arr {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, raw)
+	}
+	return items, nil
+}
@@ -0,0 +1,159 @@
+package shttp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Paginate_Link(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 2 {
+			w.Header().Set("Link", fmt.Sprintf(`</items?page=%d>; rel="next"`, page+1))
+		}
+		fmt.Fprintf(w, `{"data":[%d,%d]}`, page*2, page*2+1)
+	}))
+	defer srv.Close()
+
+	pages := shttp.New().Paginate(context.Background(), srv.URL+"/items", shttp.LinkPagination()).ItemsPath("data")
+	var got []int
+	for pages.Next() {
+		var v int
+		if err := pages.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if err := pages.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 6 || got[5] != 5 || pages.Page() != 3 {
+		t.Fatalf("got %v after %d pages", got, pages.Page())
+	}
+}
+
+func Test_Paginate_Cursor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			fmt.Fprint(w, `{"items":[1],"meta":{"next":"a"}}`)
+		case "a":
+			fmt.Fprint(w, `{"items":[2],"meta":{"next":"b"}}`)
+		default:
+			fmt.Fprint(w, `{"items":[3],"meta":{"next":null}}`)
+		}
+	}))
+	defer srv.Close()
+
+	pages := shttp.New().Paginate(context.Background(), srv.URL, shttp.CursorPagination("meta.next", "cursor")).
+		ItemsPath("items").
+		MaxPages(2)
+	n := 0
+	for pages.Next() {
+		n++
+	}
+	if pages.Err() != nil || n != 2 {
+		t.Fatalf("got %d items, err %v", n, pages.Err())
+	}
+}
+
+func Test_Paginate_PageAndOffset(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		q := r.URL.Query()
+		n, _ := strconv.Atoi(q.Get("page") + q.Get("offset"))
+		switch {
+		case q.Has("page") && n <= 2:
+			fmt.Fprintf(w, `[%d]`, n)
+		case q.Has("offset") && n < 15:
+			fmt.Fprintf(w, `[%d,%d]`, n, n+1)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer srv.Close()
+
+	collect := func(pages *shttp.Pages) []int {
+		var got []int
+		for pages.Next() {
+			var v int
+			if err := pages.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, v)
+		}
+		if err := pages.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := collect(shttp.New().Paginate(context.Background(), srv.URL, shttp.PagePagination("page", 1))); fmt.Sprint(got) != "[1 2]" {
+		t.Fatalf("pages %v", got)
+	}
+	if got := collect(shttp.New().Paginate(context.Background(), srv.URL, shttp.OffsetPagination("offset", 10))); fmt.Sprint(got) != "[10 11 12 13 14 15]" {
+		t.Fatalf("offsets %v", got)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	pages := shttp.New().Paginate(context.Background(), srv.URL, shttp.OffsetPagination("offset", 0)).MaxPages(2)
+	if got := collect(pages); len(got) != 4 || pages.Page() != 2 || atomic.LoadInt32(&requests) != 2 {
+		t.Fatalf("got %v after %d pages and %d requests", got, pages.Page(), atomic.LoadInt32(&requests))
+	}
+}
+
+func Test_Paginate_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"data":[1]}`)
+	}))
+	defer srv.Close()
+
+	client := shttp.New()
+	pages := client.Paginate(context.Background(), srv.URL, shttp.PagePagination("page", 1)).ItemsPath("data")
+	n := 0
+	for pages.Next() {
+		n++
+	}
+	if n != 1 || pages.Err() == nil || pages.Page() != 2 || pages.Response().Response().StatusCode != http.StatusInternalServerError {
+		t.Fatalf("got %d items after %d pages, err %v", n, pages.Page(), pages.Err())
+	}
+	for addr, st := range client.PoolStats() {
+		if st.InUse != 0 {
+			t.Fatalf("%s: error page connection still in use", addr)
+		}
+	}
+}
+
+func Test_Paginate_Cancel(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `[1]`)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pages := shttp.New().Paginate(ctx, srv.URL, shttp.PagePagination("page", 1))
+	if !pages.Next() {
+		t.Fatal(pages.Err())
+	}
+	cancel()
+	if pages.Next() {
+		t.Fatal("iteration continued after cancel")
+	}
+	if !errors.Is(pages.Err(), context.Canceled) || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("err %v after %d requests", pages.Err(), atomic.LoadInt32(&requests))
+	}
+}
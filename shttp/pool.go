@@ -0,0 +1,227 @@
+package shttp
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// PoolConfig configures the connection pool of the client transport.
+type PoolConfig struct {
+	// MaxIdleConns limits the idle connections across all hosts, zero
+	// means no limit.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits the idle connections kept per host.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the dialing, active and idle connections per
+	// host, requests wait for a free connection. Zero means no limit.
+	MaxConnsPerHost int
+	// IdleConnTimeout closes connections idle for longer, zero keeps them.
+	IdleConnTimeout time.Duration
+	// DisableKeepAlives uses a new connection for every request.
+	DisableKeepAlives bool
+}
+
+// DefaultPoolConfig is the pool of a client created by New.
+var DefaultPoolConfig = PoolConfig{
+	MaxIdleConns:        512,
+	MaxIdleConnsPerHost: 64,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// PoolStats are the connections of a host, keyed by "host:port".
+type PoolStats struct {
+	Open  int
+	Idle  int
+	InUse int
+	// Dials counts the connections opened since the client was created.
+	Dials int64
+}
+
+// WithPool configures the connection pool, see PoolConfig.
+func WithPool(conf PoolConfig) Option {
+	return func(c *Client) {
+		c.Pool(conf)
+	}
+}
+
+// Pool configures the connection pool, see PoolConfig.
+//
+// Deprecated: use WithPool, see Client.
+func (c *Client) Pool(conf PoolConfig) {
+	if c.built {
+		return
+	}
+	t := c.transport()
+	t.MaxIdleConns = conf.MaxIdleConns
+	t.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	t.MaxConnsPerHost = conf.MaxConnsPerHost
+	t.IdleConnTimeout = conf.IdleConnTimeout
+	t.DisableKeepAlives = conf.DisableKeepAlives
+}
+
+// PoolStats returns the connections per host opened by the client dialer.
+// Connections of a transport set with Client.Transport whose DialContext is
+// not the client's are not counted.
+func (c *Client) PoolStats() map[string]PoolStats {
+	if c.conns == nil {
+		return map[string]PoolStats{}
+	}
+	return c.conns.stats()
+}
+
+// connStats tracks the connections of a client per dialed address.
+type connStats struct {
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+	dials map[string]int64
+}
+
+func newConnStats() *connStats {
+	return &connStats{
+		conns: make(map[*trackedConn]struct{}),
+		dials: make(map[string]int64),
+	}
+}
+
+func (s *connStats) track(conn net.Conn, addr string) net.Conn {
+	tc := &trackedConn{Conn: conn, addr: addr, stats: s}
+	s.mu.Lock()
+	s.conns[tc] = struct{}{}
+	s.dials[addr]++
+	s.mu.Unlock()
+	return tc
+}
+
+func (s *connStats) stats() map[string]PoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]PoolStats, len(s.dials))
+	for addr, dials := range s.dials {
+		stats[addr] = PoolStats{Dials: dials}
+	}
+	for tc := range s.conns {
+		st := stats[tc.addr]
+		st.Open++
+		if tc.active > 0 {
+			st.InUse++
+		} else {
+			st.Idle++
+		}
+		stats[tc.addr] = st
+	}
+	return stats
+}
+
+// trackedConn is a dialed connection counted by connStats. active is the
+// number of requests using it, more than one for HTTP/2.
+type trackedConn struct {
+	net.Conn
+	addr      string
+	stats     *connStats
+	active    int
+	closeOnce sync.Once
+}
+
+func (tc *trackedConn) Close() error {
+	tc.closeOnce.Do(func() {
+		tc.stats.mu.Lock()
+		delete(tc.stats.conns, tc)
+		tc.stats.mu.Unlock()
+	})
+	return tc.Conn.Close()
+}
+
+// connTrace follows the connection of a request through its redirects.
+type connTrace struct {
+	stats *connStats
+	mu    sync.Mutex
+	conn  *trackedConn
+}
+
+func (s *connStats) withTrace(req *http.Request) (*http.Request, *connTrace) {
+	tr := &connTrace{stats: s}
+	ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			tr.release()
+			tc := unwrapTrackedConn(info.Conn)
+			if tc == nil {
+				return
+			}
+			s.mu.Lock()
+			tc.active++
+			s.mu.Unlock()
+			tr.mu.Lock()
+			tr.conn = tc
+			tr.mu.Unlock()
+		},
+		PutIdleConn: func(err error) {
+			tr.release()
+		},
+	})
+	return req.WithContext(ctx), tr
+}
+
+// release marks the current connection of the request as no longer used.
+func (tr *connTrace) release() {
+	tr.mu.Lock()
+	tc := tr.conn
+	tr.conn = nil
+	tr.mu.Unlock()
+	if tc == nil {
+		return
+	}
+	tr.stats.mu.Lock()
+	tc.active--
+	tr.stats.mu.Unlock()
+}
+
+func unwrapTrackedConn(conn net.Conn) *trackedConn {
+	for {
+		switch c := conn.(type) {
+		case *trackedConn:
+			return c
+		case *tls.Conn:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+// releaseBody releases the connection of a request when its body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// releaseOnResponse calls release when the body of resp is closed, or right
+// away when the request failed. release runs once.
+func releaseOnResponse(resp *http.Response, err error, release func()) {
+	if err != nil || resp == nil || resp.Body == nil {
+		release()
+		return
+	}
+	var once sync.Once
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { once.Do(release) }}
+}
+
+// dialContext dials a connection and counts it for PoolStats.
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := c.dialConn(ctx, network, addr)
+	if err != nil || c.conns == nil {
+		return conn, err
+	}
+	return c.conns.track(conn, addr), nil
+}
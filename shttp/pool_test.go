@@ -0,0 +1,38 @@
+package shttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_PoolStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	client := shttp.New()
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := client.PoolStats()[host]; st.Open != 1 || st.InUse != 1 {
+		t.Fatalf("unexpected stats while reading %+v", st)
+	}
+	if _, err := resp.Bytes(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetToString(srv.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := client.PoolStats()[host]; st.Open != 1 || st.Idle != 1 || st.InUse != 0 || st.Dials != 1 {
+		t.Fatalf("connection not reused %+v", st)
+	}
+}
@@ -0,0 +1,290 @@
+package shttp_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_RequestProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+	defer origin.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Write([]byte("proxy " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	p, _ := url.Parse(proxy.URL)
+	p.User = url.UserPassword("user", "pass")
+	client := shttp.New()
+
+	body, err := client.GetToString(origin.URL, shttp.ProxyHandler(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "proxy "+origin.URL+"/" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	// the override must not leak into the shared client
+	body, err = client.GetToString(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "origin" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	client = client.Clone(shttp.WithProxyUrl(p))
+	body, err = client.GetToString(origin.URL, shttp.ProxyHandler(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "origin" {
+		t.Fatalf("direct override ignored, got %q", body)
+	}
+}
+
+// socks5Server accepts SOCKS5 CONNECT requests authenticated with user and
+// pass and pipes them to the target.
+func socks5Server(t *testing.T, user, pass string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				buf := make([]byte, 2)
+				if _, err := io.ReadFull(r, buf); err != nil || buf[0] != 5 {
+					return
+				}
+				methods := make([]byte, buf[1])
+				io.ReadFull(r, methods)
+				// username/password authentication only
+				conn.Write([]byte{5, 2})
+				io.ReadFull(r, buf)
+				u := make([]byte, buf[1])
+				io.ReadFull(r, u)
+				io.ReadFull(r, buf[:1])
+				p := make([]byte, buf[0])
+				io.ReadFull(r, p)
+				if string(u) != user || string(p) != pass {
+					conn.Write([]byte{1, 1})
+					return
+				}
+				conn.Write([]byte{1, 0})
+
+				head := make([]byte, 4)
+				if _, err := io.ReadFull(r, head); err != nil || head[1] != 1 {
+					return
+				}
+				var host string
+				switch head[3] {
+				case 1:
+					ip := make([]byte, 4)
+					io.ReadFull(r, ip)
+					host = net.IP(ip).String()
+				case 3:
+					io.ReadFull(r, buf[:1])
+					name := make([]byte, buf[0])
+					io.ReadFull(r, name)
+					host = string(name)
+				default:
+					return
+				}
+				io.ReadFull(r, buf)
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf)))))
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(target, r)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln
+}
+
+func Test_Client_ProxySOCKS5(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+	defer origin.Close()
+	ln := socks5Server(t, "user", "pass")
+	defer ln.Close()
+
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		p := &url.URL{Scheme: scheme, Host: ln.Addr().String(), User: url.UserPassword("user", "pass")}
+		body, err := shttp.New(shttp.WithProxyUrl(p)).GetToString(origin.URL)
+		if err != nil {
+			t.Fatal(scheme, err)
+		}
+		if body != "origin" {
+			t.Fatalf("%s: unexpected body %q", scheme, body)
+		}
+	}
+	p := &url.URL{Scheme: "socks5", Host: ln.Addr().String(), User: url.UserPassword("user", "wrong")}
+	if _, err := shttp.New(shttp.WithProxyUrl(p)).Get(origin.URL); err == nil {
+		t.Fatal("wrong SOCKS5 password accepted")
+	}
+}
+
+func Test_Client_ProxyConnect(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Error("proxy credentials sent to the origin")
+		}
+		w.Write([]byte("origin"))
+	}))
+	defer origin.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(target, rw)
+		io.Copy(conn, target)
+	}))
+	defer proxy.Close()
+
+	p, _ := url.Parse(proxy.URL)
+	p.User = url.UserPassword("user", "pass")
+	body, err := shttp.New(shttp.WithRootCA(serverPEM(origin)), shttp.WithProxyUrl(p)).GetToString(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "origin" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	p.User = nil
+	if _, err := shttp.New(shttp.WithRootCA(serverPEM(origin)), shttp.WithProxyUrl(p)).Get(origin.URL); err == nil {
+		t.Fatal("CONNECT without credentials succeeded")
+	}
+}
+
+func Test_Client_ProxyFromEnvironment(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+	defer origin.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxy " + r.URL.Host))
+	}))
+	defer proxy.Close()
+	_, port, _ := net.SplitHostPort(origin.Listener.Addr().String())
+
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("NO_PROXY", "direct.test")
+	client := shttp.New(shttp.WithProxyFromEnvironment(),
+		shttp.WithHostOverride("direct.test", "127.0.0.1"),
+		shttp.WithHostOverride("proxied.test", "127.0.0.1"))
+
+	body, err := client.GetToString("http://proxied.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "proxy proxied.test:"+port {
+		t.Fatalf("unexpected body %q", body)
+	}
+	body, err = client.GetToString("http://direct.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "origin" {
+		t.Fatalf("NO_PROXY host proxied, got %q", body)
+	}
+}
+
+func Test_Client_RequestProxy_KeptByWithContext(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "origin", http.StatusInternalServerError)
+	}))
+	defer origin.Close()
+	var hits int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: proxied\n\n")
+	}))
+	defer proxy.Close()
+	p, _ := url.Parse(proxy.URL)
+	client := shttp.New()
+
+	body, err := client.GetToString(origin.URL, shttp.ProxyHandler(p), func(c *shttp.Client, req *shttp.Request) {
+		req.WithContext(context.Background())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "data: proxied\n\n" {
+		t.Fatalf("proxy dropped by WithContext, got %q", body)
+	}
+
+	stop := errors.New("stop")
+	err = client.EventStream(origin.URL, shttp.ProxyHandler(p)).Subscribe(context.Background(), func(e *shttp.Event) error {
+		if e.Data != "proxied" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	wsProxy := frameServer(t, "", nil, nil)
+	defer wsProxy.Close()
+	wp, _ := url.Parse(wsProxy.URL)
+	ws, _, err := client.WebSocket("ws"+strings.TrimPrefix(origin.URL, "http"), shttp.ProxyHandler(wp)).Dial(context.Background())
+	if err != nil {
+		t.Fatalf("websocket not dialed through the proxy: %v", err)
+	}
+	ws.Close()
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("expected 2 proxied requests, got %d", n)
+	}
+}
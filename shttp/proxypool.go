@@ -0,0 +1,457 @@
+package shttp
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrNoProxyAvailable is returned when every proxy of a pool is ejected.
+var ErrNoProxyAvailable = errors.New("no proxy available")
+
+// ProxySelection is the way a ProxyPool picks the proxy of a request.
+type ProxySelection int
+
+const (
+	// ProxyRoundRobin cycles through the healthy proxies.
+	ProxyRoundRobin ProxySelection = iota
+	// ProxyRandom picks a random healthy proxy.
+	ProxyRandom
+	// ProxyLeastFailures picks the healthy proxy with the fewest failures.
+	ProxyLeastFailures
+	// ProxySticky keeps the same proxy for the same key, see
+	// WithProxyStickyKey and Request.ProxyKey.
+	ProxySticky
+)
+
+type ProxyPoolOption func(*ProxyPool)
+
+// WithProxySelection sets how the proxy of a request is picked, round-robin
+// by default.
+func WithProxySelection(s ProxySelection) ProxyPoolOption {
+	return func(p *ProxyPool) {
+		p.selection = s
+	}
+}
+
+// WithProxyMaxFailures ejects a proxy after n consecutive failures, 3 by
+// default.
+func WithProxyMaxFailures(n int) ProxyPoolOption {
+	return func(p *ProxyPool) {
+		p.maxFailures = n
+	}
+}
+
+// WithProxyEjectStatus counts responses with one of the status codes as
+// failures, e.g. 403 or 429 from blocked egress addresses.
+func WithProxyEjectStatus(codes ...int) ProxyPoolOption {
+	return func(p *ProxyPool) {
+		for _, code := range codes {
+			p.ejectStatus[code] = true
+		}
+	}
+}
+
+// WithProxyEjectTimeout re-admits an ejected proxy after d when no probe is
+// configured, 30 seconds by default.
+func WithProxyEjectTimeout(d time.Duration) ProxyPoolOption {
+	return func(p *ProxyPool) {
+		p.ejectTimeout = d
+	}
+}
+
+// WithProxyProbe checks the ejected proxies every interval with a GET of
+// rawUrl through the proxy and re-admits those that answer with a status
+// below 400. The probes use the transport of the first client of the pool,
+// with its TLS, dialer and timeout settings, and wait until there is one.
+func WithProxyProbe(rawUrl string, interval time.Duration) ProxyPoolOption {
+	return func(p *ProxyPool) {
+		p.probeUrl = rawUrl
+		p.probeInterval = interval
+	}
+}
+
+// WithProxyStickyKey sets the key of ProxySticky, the request host by
+// default. Request.ProxyKey takes precedence. The pool remembers the proxy
+// of the 1024 most recently used keys.
+func WithProxyStickyKey(fn func(r *http.Request) string) ProxyPoolOption {
+	return func(p *ProxyPool) {
+		p.stickyKey = fn
+	}
+}
+
+// ProxyStats are the counters of a proxy in a pool.
+type ProxyStats struct {
+	Url                 *url.URL
+	Requests            int64
+	Failures            int64
+	ConsecutiveFailures int
+	InFlight            int
+	Ejected             bool
+	EjectedAt           time.Time
+	LastError           error
+}
+
+type poolProxy struct {
+	stats ProxyStats
+}
+
+const maxStickyKeys = 1024
+
+type stickyProxy struct {
+	key string
+	px  *poolProxy
+}
+
+// ProxyPool spreads requests across proxies and ejects the unhealthy ones.
+// Plug it into a client with WithProxyPool. A per-request proxy set with
+// Request.Proxy bypasses the pool.
+type ProxyPool struct {
+	mu        sync.Mutex
+	proxies   []*poolProxy
+	next      int
+	sticky    map[string]*list.Element
+	stickyLRU *list.List
+	rnd       *rand.Rand
+	probeStop chan struct{}
+	closeOnce sync.Once
+	// probeTransport is the transport of the first client of the pool
+	probeTransport http.RoundTripper
+
+	selection     ProxySelection
+	maxFailures   int
+	ejectStatus   map[int]bool
+	ejectTimeout  time.Duration
+	probeUrl      string
+	probeInterval time.Duration
+	stickyKey     func(r *http.Request) string
+}
+
+// NewProxyPool returns a pool of the proxy urls, see Client.ProxyUrl for the
+// supported schemes. Close stops the probes.
+func NewProxyPool(proxies []string, opts ...ProxyPoolOption) (*ProxyPool, error) {
+	p := &ProxyPool{
+		sticky:       make(map[string]*list.Element),
+		stickyLRU:    list.New(),
+		rnd:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		probeStop:    make(chan struct{}),
+		maxFailures:  3,
+		ejectStatus:  make(map[int]bool),
+		ejectTimeout: 30 * time.Second,
+		stickyKey: func(r *http.Request) string {
+			return r.URL.Host
+		},
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	for _, raw := range proxies {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.Add(u)
+	}
+	if p.probeUrl != "" && p.probeInterval > 0 {
+		go p.probeLoop()
+	}
+	return p, nil
+}
+
+// Add adds a proxy to the pool.
+func (p *ProxyPool) Add(u *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, px := range p.proxies {
+		if px.stats.Url.String() == u.String() {
+			return
+		}
+	}
+	p.proxies = append(p.proxies, &poolProxy{stats: ProxyStats{Url: u}})
+}
+
+// Remove removes a proxy from the pool.
+func (p *ProxyPool) Remove(u *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, px := range p.proxies {
+		if px.stats.Url.String() == u.String() {
+			p.proxies = append(p.proxies[:i], p.proxies[i+1:]...)
+			break
+		}
+	}
+	for k, e := range p.sticky {
+		if e.Value.(*stickyProxy).px.stats.Url.String() == u.String() {
+			p.stickyLRU.Remove(e)
+			delete(p.sticky, k)
+		}
+	}
+}
+
+// Stats returns a snapshot of the counters of every proxy.
+func (p *ProxyPool) Stats() []ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]ProxyStats, 0, len(p.proxies))
+	for _, px := range p.proxies {
+		stats = append(stats, px.stats)
+	}
+	return stats
+}
+
+// Close stops the re-admission probes.
+func (p *ProxyPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.probeStop)
+	})
+}
+
+// pick selects the proxy of r, re-admitting proxies whose ejection timed out.
+func (p *ProxyPool) pick(r *http.Request) (*poolProxy, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	healthy := make([]*poolProxy, 0, len(p.proxies))
+	for _, px := range p.proxies {
+		if px.stats.Ejected && p.probeUrl == "" && time.Since(px.stats.EjectedAt) >= p.ejectTimeout {
+			px.readmit()
+		}
+		if !px.stats.Ejected {
+			healthy = append(healthy, px)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, ErrNoProxyAvailable
+	}
+
+	var px *poolProxy
+	switch p.selection {
+	case ProxyRandom:
+		px = healthy[p.rnd.Intn(len(healthy))]
+	case ProxyLeastFailures:
+		px = healthy[0]
+		for _, h := range healthy[1:] {
+			if h.stats.Failures < px.stats.Failures ||
+				(h.stats.Failures == px.stats.Failures && h.stats.InFlight < px.stats.InFlight) {
+				px = h
+			}
+		}
+	case ProxySticky:
+		key, ok := r.Context().Value(proxyPoolKey{}).(string)
+		if !ok {
+			key = p.stickyKey(r)
+		}
+		px = p.stickyProxy(key, healthy)
+	default:
+		px = healthy[p.next%len(healthy)]
+		p.next++
+	}
+	px.stats.Requests++
+	px.stats.InFlight++
+	return px, nil
+}
+
+// stickyProxy returns the proxy of key, assigning the next healthy one when
+// it has none or its proxy is ejected or removed.
+func (p *ProxyPool) stickyProxy(key string, healthy []*poolProxy) *poolProxy {
+	if e, ok := p.sticky[key]; ok {
+		s := e.Value.(*stickyProxy)
+		if !s.px.stats.Ejected && p.contains(s.px) {
+			p.stickyLRU.MoveToFront(e)
+			return s.px
+		}
+		p.stickyLRU.Remove(e)
+		delete(p.sticky, key)
+	}
+	px := healthy[p.next%len(healthy)]
+	p.next++
+	p.sticky[key] = p.stickyLRU.PushFront(&stickyProxy{key: key, px: px})
+	if p.stickyLRU.Len() > maxStickyKeys {
+		oldest := p.stickyLRU.Back()
+		p.stickyLRU.Remove(oldest)
+		delete(p.sticky, oldest.Value.(*stickyProxy).key)
+	}
+	return px
+}
+
+func (p *ProxyPool) contains(px *poolProxy) bool {
+	for _, h := range p.proxies {
+		if h == px {
+			return true
+		}
+	}
+	return false
+}
+
+// done records the outcome of a request sent through px.
+func (p *ProxyPool) done(px *poolProxy, resp *http.Response, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrHTTP2Proxy)):
+		// not a failure of the proxy, ErrHTTP2Proxy did not use it
+		return
+	case err == nil && !p.ejectStatus[resp.StatusCode]:
+		px.stats.ConsecutiveFailures = 0
+		return
+	case err == nil:
+		err = errors.New("proxy response status " + resp.Status)
+	}
+	px.stats.Failures++
+	px.stats.ConsecutiveFailures++
+	px.stats.LastError = err
+	if p.maxFailures > 0 && px.stats.ConsecutiveFailures >= p.maxFailures && !px.stats.Ejected {
+		px.stats.Ejected = true
+		px.stats.EjectedAt = time.Now()
+	}
+}
+
+// release ends a request sent through px, once its response body is closed.
+func (p *ProxyPool) release(px *poolProxy) {
+	p.mu.Lock()
+	px.stats.InFlight--
+	p.mu.Unlock()
+}
+
+func (px *poolProxy) readmit() {
+	px.stats.Ejected = false
+	px.stats.ConsecutiveFailures = 0
+}
+
+func (p *ProxyPool) probeLoop() {
+	ticker := time.NewTicker(p.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.probeStop:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		transport := p.probeTransport
+		ejected := make([]*poolProxy, 0)
+		for _, px := range p.proxies {
+			if px.stats.Ejected {
+				ejected = append(ejected, px)
+			}
+		}
+		p.mu.Unlock()
+		if transport == nil {
+			continue
+		}
+		hc := &http.Client{Transport: transport, Timeout: p.probeInterval}
+		for _, px := range ejected {
+			if p.probe(hc, px.stats.Url) {
+				p.mu.Lock()
+				px.readmit()
+				p.mu.Unlock()
+			}
+		}
+	}
+}
+
+// useTransport sends the probes with the transport of a client of the pool,
+// it must resolve the proxy of a request with Client.proxyFunc.
+func (p *ProxyPool) useTransport(rt http.RoundTripper) {
+	p.mu.Lock()
+	if p.probeTransport == nil {
+		p.probeTransport = rt
+	}
+	p.mu.Unlock()
+}
+
+func (p *ProxyPool) probe(hc *http.Client, proxy *url.URL) bool {
+	req, err := http.NewRequest(http.MethodGet, p.probeUrl, nil)
+	if err != nil {
+		return false
+	}
+	req = req.WithContext(context.WithValue(req.Context(), proxyKey{}, proxy))
+	resp, err := hc.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+type proxyPoolKey struct{}
+
+// ProxyKey sets the key used by a ProxySticky pool for this request.
+func (r *Request) ProxyKey(key string) {
+	r.setContextValue(proxyPoolKey{}, key)
+}
+
+// WithProxyPool sends the requests through the proxies of pool.
+func WithProxyPool(pool *ProxyPool) Option {
+	return func(c *Client) {
+		c.ProxyPool(pool)
+	}
+}
+
+// ProxyPool sends the requests through the proxies of pool, a nil pool
+// removes it.
+//
+// Deprecated: use WithProxyPool, see Client.
+func (c *Client) ProxyPool(pool *ProxyPool) {
+	if c.built {
+		return
+	}
+	if rt, ok := c.c.Transport.(*proxyPoolRoundTripper); ok {
+		c.c.Transport = rt.next
+	}
+	if pool == nil {
+		return
+	}
+	c.useProxyFunc()
+	c.c.Transport = &proxyPoolRoundTripper{
+		next: c.c.Transport,
+		pool: pool,
+	}
+}
+
+// proxyPoolRoundTripper picks the proxy of every request from the pool and
+// reports the outcome back to it.
+type proxyPoolRoundTripper struct {
+	next http.RoundTripper
+	pool *ProxyPool
+}
+
+func (rt *proxyPoolRoundTripper) unwrap() http.RoundTripper {
+	return rt.next
+}
+
+func (rt *proxyPoolRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := requestProxy(req); ok {
+		return rt.next.RoundTrip(req)
+	}
+	px, err := rt.pool.pick(req)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(context.WithValue(req.Context(), proxyKey{}, px.stats.Url))
+	resp, err := rt.next.RoundTrip(req)
+	rt.pool.done(px, resp, err)
+	releaseOnResponse(resp, err, func() { rt.pool.release(px) })
+	return resp, err
+}
+
+// findProxyPool returns the proxyPoolRoundTripper of rt or below its
+// wrappers, nil when the client has no pool.
+func findProxyPool(rt http.RoundTripper) *proxyPoolRoundTripper {
+	for rt != nil {
+		if p, ok := rt.(*proxyPoolRoundTripper); ok {
+			return p
+		}
+		w, ok := rt.(transportWrapper)
+		if !ok {
+			return nil
+		}
+		rt = w.unwrap()
+	}
+	return nil
+}
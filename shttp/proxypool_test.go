@@ -0,0 +1,210 @@
+package shttp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+// namedProxies starts n proxies answering with their index, or 429 while
+// their failing flag is set.
+func namedProxies(t *testing.T, n int) ([]string, []*int32) {
+	urls := make([]string, n)
+	failing := make([]*int32, n)
+	for i := range urls {
+		i := i
+		failing[i] = new(int32)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(failing[i]) != 0 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, i)
+		}))
+		t.Cleanup(srv.Close)
+		urls[i] = srv.URL
+	}
+	return urls, failing
+}
+
+func poolSequence(t *testing.T, client *shttp.Client, n int, handlers ...shttp.RequestHandler) string {
+	var seq strings.Builder
+	for i := 0; i < n; i++ {
+		body, err := client.GetToString("http://example.invalid/", handlers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq.WriteString(body)
+	}
+	return seq.String()
+}
+
+func Test_ProxyPool_Selection(t *testing.T) {
+	urls, failing := namedProxies(t, 3)
+
+	pool, _ := shttp.NewProxyPool(urls)
+	if seq := poolSequence(t, shttp.New(shttp.WithProxyPool(pool)), 6); seq != "012012" {
+		t.Fatalf("round-robin sequence %s", seq)
+	}
+
+	pool, _ = shttp.NewProxyPool(urls, shttp.WithProxySelection(shttp.ProxyRandom))
+	poolSequence(t, shttp.New(shttp.WithProxyPool(pool)), 60)
+	for i, s := range pool.Stats() {
+		if s.Requests == 0 {
+			t.Fatalf("random selection never picked proxy %d", i)
+		}
+	}
+
+	atomic.StoreInt32(failing[0], 1)
+	pool, _ = shttp.NewProxyPool(urls,
+		shttp.WithProxySelection(shttp.ProxyLeastFailures),
+		shttp.WithProxyEjectStatus(http.StatusTooManyRequests),
+		shttp.WithProxyMaxFailures(0),
+	)
+	client := shttp.New(shttp.WithProxyPool(pool))
+	poolSequence(t, client, 1)
+	if seq := poolSequence(t, client, 3); seq != "111" {
+		t.Fatalf("least-failures sequence %s", seq)
+	}
+	atomic.StoreInt32(failing[0], 0)
+
+	pool, _ = shttp.NewProxyPool(urls, shttp.WithProxySelection(shttp.ProxySticky))
+	client = shttp.New(shttp.WithProxyPool(pool))
+	key := func(k string) shttp.RequestHandler {
+		return func(c *shttp.Client, req *shttp.Request) {
+			req.ProxyKey(k)
+		}
+	}
+	a, b := poolSequence(t, client, 3, key("a")), poolSequence(t, client, 3, key("b"))
+	if a != "000" || b != "111" || poolSequence(t, client, 1, key("a")) != "0" {
+		t.Fatalf("sticky sequences %s %s", a, b)
+	}
+}
+
+func Test_ProxyPool_Eject(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("good"))
+	}))
+	defer good.Close()
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer blocked.Close()
+
+	pool, err := shttp.NewProxyPool([]string{blocked.URL, good.URL},
+		shttp.WithProxyMaxFailures(1),
+		shttp.WithProxyEjectStatus(http.StatusTooManyRequests),
+		shttp.WithProxyEjectTimeout(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	client := shttp.New(shttp.WithProxyPool(pool))
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.GetToString("http://example.invalid/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := pool.Stats()
+	if !stats[0].Ejected || stats[0].Requests != 1 || stats[1].Ejected || stats[1].Requests != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func Test_ProxyPool_NoProxyAvailable(t *testing.T) {
+	urls, failing := namedProxies(t, 1)
+	atomic.StoreInt32(failing[0], 1)
+	pool, _ := shttp.NewProxyPool(urls,
+		shttp.WithProxyMaxFailures(1),
+		shttp.WithProxyEjectStatus(http.StatusTooManyRequests),
+		shttp.WithProxyEjectTimeout(time.Hour),
+	)
+	client := shttp.New(shttp.WithProxyPool(pool))
+	if _, err := client.Get("http://example.invalid/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get("http://example.invalid/"); !errors.Is(err, shttp.ErrNoProxyAvailable) {
+		t.Fatalf("expected ErrNoProxyAvailable, got %v", err)
+	}
+}
+
+func Test_ProxyPool_TimeoutReadmission(t *testing.T) {
+	urls, failing := namedProxies(t, 1)
+	atomic.StoreInt32(failing[0], 1)
+	pool, _ := shttp.NewProxyPool(urls,
+		shttp.WithProxyMaxFailures(1),
+		shttp.WithProxyEjectStatus(http.StatusTooManyRequests),
+		shttp.WithProxyEjectTimeout(50*time.Millisecond),
+	)
+	client := shttp.New(shttp.WithProxyPool(pool))
+	client.Get("http://example.invalid/")
+	if !pool.Stats()[0].Ejected {
+		t.Fatal("failing proxy not ejected")
+	}
+	atomic.StoreInt32(failing[0], 0)
+	time.Sleep(60 * time.Millisecond)
+	if seq := poolSequence(t, client, 1); seq != "0" || pool.Stats()[0].Ejected {
+		t.Fatalf("proxy not re-admitted after the timeout, got %q", seq)
+	}
+}
+
+func Test_ProxyPool_ProbeReadmission(t *testing.T) {
+	urls, failing := namedProxies(t, 1)
+	atomic.StoreInt32(failing[0], 1)
+	// the proxy host only resolves through the client's host override
+	port := urls[0][strings.LastIndex(urls[0], ":"):]
+	pool, _ := shttp.NewProxyPool([]string{"http://proxy.test" + port},
+		shttp.WithProxyMaxFailures(1),
+		shttp.WithProxyEjectStatus(http.StatusTooManyRequests),
+		shttp.WithProxyProbe("http://example.invalid/health", 20*time.Millisecond),
+	)
+	defer pool.Close()
+	client := shttp.New(shttp.WithProxyPool(pool), shttp.WithHostOverride("proxy.test", "127.0.0.1"))
+	client.Get("http://example.invalid/")
+	if !pool.Stats()[0].Ejected {
+		t.Fatal("failing proxy not ejected")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !pool.Stats()[0].Ejected {
+		t.Fatal("failing proxy re-admitted by the probe")
+	}
+	atomic.StoreInt32(failing[0], 0)
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats()[0].Ejected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pool.Stats()[0].Ejected {
+		t.Fatal("healthy proxy not re-admitted by the probe")
+	}
+}
+
+func Test_ProxyPool_InFlightUntilBodyClosed(t *testing.T) {
+	proxy := holdingEventServer()
+	defer proxy.Close()
+
+	pool, _ := shttp.NewProxyPool([]string{proxy.URL})
+	client := shttp.New(shttp.WithProxyPool(pool))
+	errStop := errors.New("stop")
+	err := client.EventStream("http://example.invalid/").Subscribe(context.Background(), func(e *shttp.Event) error {
+		if n := pool.Stats()[0].InFlight; n != 1 {
+			t.Errorf("%d requests in flight while reading the body", n)
+		}
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatal(err)
+	}
+	if n := pool.Stats()[0].InFlight; n != 0 {
+		t.Fatalf("%d requests in flight after the body was closed", n)
+	}
+}
@@ -0,0 +1,148 @@
+package shttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectBodyMode is the handling of a 307/308 redirect of a request with
+// a body.
+type RedirectBodyMode int
+
+const (
+	// RedirectBodyResend sends the body again to the new location. A body
+	// that can not be replayed returns the redirect response instead.
+	RedirectBodyResend RedirectBodyMode = iota
+	// RedirectBodyStop returns the redirect response.
+	RedirectBodyStop
+	// RedirectBodyError fails the request.
+	RedirectBodyError
+)
+
+// RedirectPolicy decides which redirects are followed.
+type RedirectPolicy struct {
+	// MaxRedirects is the number of followed redirects before the request
+	// fails, 5 when zero.
+	MaxRedirects int
+	// Disable returns the first redirect response instead of following it.
+	Disable bool
+	// SameHostOnly returns the redirect response when the location is on
+	// another host than the original request.
+	SameHostOnly bool
+	// AllowedSchemes returns the redirect response when the location has
+	// another scheme, http and https when empty.
+	AllowedSchemes []string
+	// ForwardAuthorization keeps the Authorization header on redirects to
+	// other domains, where it is dropped by default.
+	ForwardAuthorization bool
+	// ForwardCookies keeps the Cookie header on redirects to other domains,
+	// where it is dropped by default. Cookies of the jar are not affected.
+	ForwardCookies bool
+	// Body is the handling of 307 and 308 redirects of requests with a body.
+	Body RedirectBodyMode
+}
+
+// DefaultRedirectPolicy is the redirect policy of a client created by New.
+var DefaultRedirectPolicy = RedirectPolicy{
+	MaxRedirects: 5,
+}
+
+// RedirectHop is a redirect response that was followed.
+type RedirectHop struct {
+	URL        *url.URL
+	StatusCode int
+}
+
+type redirectHistoryKey struct{}
+
+type redirectHistory struct {
+	hops []RedirectHop
+}
+
+// WithRedirectPolicy sets the redirect policy, see RedirectPolicy.
+func WithRedirectPolicy(p RedirectPolicy) Option {
+	return func(c *Client) {
+		c.RedirectPolicy(p)
+	}
+}
+
+// RedirectPolicy sets the redirect policy, see RedirectPolicy.
+//
+// Deprecated: use WithRedirectPolicy, see Client.
+func (c *Client) RedirectPolicy(p RedirectPolicy) {
+	if c.built {
+		return
+	}
+	c.redirect = p
+	c.c.CheckRedirect = c.checkRedirect
+}
+
+// Redirects returns the followed redirects, in order, that led to the response.
+func (r *Response) Redirects() []RedirectHop {
+	return r.redirects
+}
+
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	p := c.redirect
+	first := via[0]
+	if p.Disable {
+		return http.ErrUseLastResponse
+	}
+	max := p.MaxRedirects
+	if max <= 0 {
+		max = DefaultRedirectPolicy.MaxRedirects
+	}
+	if len(via) >= max {
+		return fmt.Errorf("stopped after %d redirects", max)
+	}
+	if p.SameHostOnly && !strings.EqualFold(req.URL.Host, first.URL.Host) {
+		return http.ErrUseLastResponse
+	}
+	schemes := p.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	allowed := false
+	for _, s := range schemes {
+		allowed = allowed || strings.EqualFold(s, req.URL.Scheme)
+	}
+	if !allowed {
+		return http.ErrUseLastResponse
+	}
+	if resp := req.Response; resp != nil &&
+		(resp.StatusCode == http.StatusTemporaryRedirect || resp.StatusCode == http.StatusPermanentRedirect) &&
+		first.ContentLength != 0 {
+		switch p.Body {
+		case RedirectBodyStop:
+			return http.ErrUseLastResponse
+		case RedirectBodyError:
+			return errors.New("redirect " + resp.Status + " of a request with a body")
+		}
+	}
+
+	if p.ForwardAuthorization {
+		copyHeaderIfMissing(req.Header, first.Header, "Authorization")
+	}
+	if p.ForwardCookies {
+		copyHeaderIfMissing(req.Header, first.Header, "Cookie")
+	}
+
+	if h, ok := req.Context().Value(redirectHistoryKey{}).(*redirectHistory); ok && req.Response != nil {
+		h.hops = append(h.hops, RedirectHop{
+			URL:        via[len(via)-1].URL,
+			StatusCode: req.Response.StatusCode,
+		})
+	}
+	return nil
+}
+
+func copyHeaderIfMissing(dst, src http.Header, key string) {
+	if _, ok := dst[key]; !ok {
+		if v, ok := src[key]; ok {
+			dst[key] = v
+		}
+	}
+}
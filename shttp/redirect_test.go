@@ -0,0 +1,77 @@
+package shttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_Redirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer other.Close()
+	// the other server is reached through localhost, another host name
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		case "/post":
+			http.Redirect(w, r, "/echo", http.StatusTemporaryRedirect)
+		case "/echo":
+			io.Copy(w, r.Body)
+		case "/away":
+			http.Redirect(w, r, otherURL, http.StatusFound)
+		default:
+			w.Write([]byte("done"))
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := shttp.New().Get(srv.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hops := resp.Redirects()
+	if len(hops) != 2 || hops[0].URL.Path != "/a" || hops[0].StatusCode != http.StatusFound ||
+		hops[1].URL.Path != "/b" || hops[1].StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("unexpected hops %+v", hops)
+	}
+
+	body, err := shttp.New().PostToString(srv.URL+"/post", func(c *shttp.Client, req *shttp.Request) {
+		req.Body([]byte("payload"))
+	})
+	if err != nil || body != "payload" {
+		t.Fatalf("307 body not resent: %q %v", body, err)
+	}
+	resp, err = shttp.New(shttp.WithRedirectPolicy(shttp.RedirectPolicy{Body: shttp.RedirectBodyStop})).
+		Post(srv.URL+"/post", func(c *shttp.Client, req *shttp.Request) {
+			req.Body([]byte("payload"))
+		})
+	if err != nil || resp.Response().StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("307 followed despite policy: %v", err)
+	}
+
+	auth := func(c *shttp.Client, req *shttp.Request) {
+		req.Header("Authorization", "Bearer token")
+	}
+	if body, _ := shttp.New().GetToString(srv.URL+"/away", auth); body != "" {
+		t.Fatalf("authorization leaked cross-host: %q", body)
+	}
+	client := shttp.New(shttp.WithRedirectPolicy(shttp.RedirectPolicy{ForwardAuthorization: true}))
+	if body, _ := client.GetToString(srv.URL+"/away", auth); body != "Bearer token" {
+		t.Fatalf("authorization not forwarded: %q", body)
+	}
+	client = shttp.New(shttp.WithRedirectPolicy(shttp.RedirectPolicy{SameHostOnly: true}))
+	if resp, err := client.Get(srv.URL + "/away"); err != nil || resp.Response().StatusCode != http.StatusFound {
+		t.Fatalf("cross-host redirect followed: %v", err)
+	}
+}
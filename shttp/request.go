@@ -2,6 +2,8 @@ package shttp
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -31,11 +33,12 @@ const (
 )
 
 type (
-	Method   string
-	Header   map[string]string
-	Query    map[string]string
-	PostForm map[string]string
-	Json     map[string]interface{}
+	Method     string
+	Header     map[string]string
+	Query      map[string]string
+	PostForm   map[string]string
+	Json       map[string]interface{}
+	PathParams map[string]string
 )
 
 func (m Method) String() string {
@@ -50,6 +53,26 @@ type Request struct {
 	headers  url.Values
 
 	body []byte
+
+	bodyFunc         func() (io.ReadCloser, error)
+	bodyOnce         bool
+	bodyLength       int64
+	contentLength    int64
+	contentLengthSet bool
+
+	pathParams map[string]string
+	timeouts   Timeouts
+	tlsConfig  *tls.Config
+	charset    string
+	bodyLimits BodyLimits
+
+	uploadLimit   *RateLimiter
+	downloadLimit *RateLimiter
+
+	// contextValues are the per-request settings read by the round
+	// trippers, attached to the context in buildRequest so that a later
+	// WithContext keeps them
+	contextValues map[interface{}]interface{}
 }
 
 func NewRequest(req *http.Request) *Request {
@@ -103,6 +126,18 @@ func (r *Request) Url(rawUrl string) error {
 	return nil
 }
 
+// WithContext sets the context used to send the request.
+func (r *Request) WithContext(ctx context.Context) {
+	if ctx != nil {
+		r.req = *r.req.WithContext(ctx)
+	}
+}
+
+// Context returns the context of the request.
+func (r *Request) Context() context.Context {
+	return r.req.Context()
+}
+
 func (r *Request) Method(method Method) {
 	r.req.Method = method.String()
 }
@@ -175,16 +210,30 @@ func (r *Request) AddCookies(c []*http.Cookie) {
 	}
 }
 
+// ProtocolVersion sends the request with HTTP/1.1, e.g.
+// ProtocolVersion("HTTP/1.1", 1, 1), or HTTP/2, ProtocolVersion("HTTP/2.0",
+// 2, 0), instead of the protocol negotiated by the client. The choice needs
+// a client with WithHTTP2, HTTP/2 is sent with prior knowledge to http urls.
+// An empty proto restores the negotiation.
 func (r *Request) ProtocolVersion(proto string, protoMajor, protoMinor int) {
 	if proto == "" {
 		r.req.Proto = protocolVersion
 		r.req.ProtoMajor = 1
 		r.req.ProtoMinor = 0
+		protoMajor = 0
 	} else {
 		r.req.Proto = proto
 		r.req.ProtoMajor = protoMajor
 		r.req.ProtoMinor = protoMinor
 	}
+	r.setContextValue(protoKey{}, protoMajor)
+}
+
+func (r *Request) setContextValue(key, value interface{}) {
+	if r.contextValues == nil {
+		r.contextValues = make(map[interface{}]interface{})
+	}
+	r.contextValues[key] = value
 }
 
 func (r *Request) Query(key, value string) {
@@ -322,7 +371,7 @@ func jsonMarshal(obj interface{}) ([]byte, error) {
 func (r *Request) buildFormBody() {
 	// build POST/PUT/PATCH/DELETE url and body
 	if !(r.req.Method == POST.String() || r.req.Method == PUT.String() ||
-		r.req.Method == PATCH.String() || r.req.Method == DELETE.String()) || r.body != nil {
+		r.req.Method == PATCH.String() || r.req.Method == DELETE.String()) || r.body != nil || r.bodyFunc != nil {
 		return
 	}
 	// with params
@@ -338,10 +387,26 @@ func (r *Request) buildFormBody() {
 }
 
 func (r *Request) buildRequest() (*http.Request, error) {
+	if err := r.buildPath(); err != nil {
+		return nil, err
+	}
+	if len(r.contextValues) > 0 {
+		ctx := r.req.Context()
+		for k, v := range r.contextValues {
+			ctx = context.WithValue(ctx, k, v)
+		}
+		r.req = *r.req.WithContext(ctx)
+	}
 	r.buildFormBody()
 	if r.body != nil && len(r.body) > 0 {
-		r.req.ContentLength = int64(len(r.body))
-		r.req.Body = io.NopCloser(bytes.NewReader(r.body))
+		body := r.body
+		r.req.ContentLength = int64(len(body))
+		r.req.Body = io.NopCloser(bytes.NewReader(body))
+		r.req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	} else if err := r.buildStreamBody(); err != nil {
+		return nil, err
 	}
 
 	if r.queries != nil && len(r.queries) > 0 {
@@ -12,6 +12,16 @@ type Response struct {
 	resp http.Response
 
 	body []byte
+
+	redirects []RedirectHop
+	// charset is the forced charset label of Text
+	charset string
+	limits  BodyLimits
+
+	// json is the body decoded by JSONPath
+	json       interface{}
+	jsonErr    error
+	jsonParsed bool
 }
 
 func NewResponse(resp *http.Response) *Response {
@@ -27,6 +37,11 @@ func (r *Response) Response() *http.Response {
 	return &r.resp
 }
 
+// Proto returns the protocol the response was received with, e.g. "HTTP/2.0".
+func (r *Response) Proto() string {
+	return r.resp.Proto
+}
+
 func (r *Response) Ok() bool {
 	return r.resp.StatusCode == http.StatusOK
 }
@@ -39,11 +54,11 @@ func (r *Response) Bytes() ([]byte, error) {
 	if r.body != nil {
 		return r.body, nil
 	}
+	defer r.resp.Body.Close()
 	bs, err := io.ReadAll(r.resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	defer r.resp.Body.Close()
 
 	// handle gzip
 	contentEncode := r.resp.Header.Get(httpHeaderContentEncoding)
@@ -52,7 +67,7 @@ func (r *Response) Bytes() ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		bs, err = io.ReadAll(reader)
+		bs, err = readDecompressed(reader, len(bs), r.limits)
 		if err != nil {
 			return nil, err
 		}
@@ -62,10 +77,7 @@ func (r *Response) Bytes() ([]byte, error) {
 	return bs, nil
 }
 
+// String returns the body decoded to UTF-8, see Text.
 func (r *Response) String() (string, error) {
-	if bs, err := r.Bytes(); err != nil {
-		return "", err
-	} else {
-		return string(bs), nil
-	}
+	return r.Text()
 }
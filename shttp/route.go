@@ -0,0 +1,431 @@
+package shttp
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route declares the method and path template of an endpoint struct, whose
+// fields are sent as path parameters, query parameters, headers, form
+// values or JSON body members by their tags:
+//
+//	type GetUser struct {
+//		shttp.Route `method:"GET" path:"/users/{id}"`
+//		ID     int64    `path:"id"`
+//		Fields []string `query:"fields,omitempty"`
+//		Token  string   `header:"Authorization,required"`
+//	}
+//
+//	type CreateUser struct {
+//		shttp.Route `method:"POST" path:"/users"`
+//		Name  string `json:"name,required"`
+//		Email string `json:"email,omitempty"`
+//	}
+//
+// The tag options are omitempty, which skips a zero value, and required,
+// which fails the call with a *FieldError on a zero value. Untagged embedded
+// structs share their fields. A struct has either form or json fields.
+type Route struct{}
+
+// FieldError is returned by Call when a field of an endpoint struct is
+// invalid.
+type FieldError struct {
+	Route  string
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("shttp: %s.%s %s", e.Route, e.Field, e.Reason)
+}
+
+var (
+	routeType        = reflect.TypeOf(Route{})
+	timeType         = reflect.TypeOf(time.Time{})
+	textMarshaler    = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	routePlaceholder = regexp.MustCompile(`\{([^{}/]+)\}`)
+	routeInfos       sync.Map
+)
+
+const (
+	routePath = iota
+	routeQuery
+	routeHeader
+	routeForm
+	routeJSON
+)
+
+var routeTags = []string{"path", "query", "header", "form", "json"}
+
+type routeField struct {
+	index     []int
+	name      string
+	kind      int
+	omitEmpty bool
+	required  bool
+}
+
+type routeInfo struct {
+	name   string
+	method string
+	path   string
+	fields []routeField
+	// json reports json fields, the body is sent even when they are empty
+	json bool
+	err  error
+}
+
+// Call sends the request declared by the endpoint struct route, see Route,
+// and decodes the JSON body of a 2xx response into result, which may be
+// nil. The response is also returned with the error of a non 2xx status.
+// The handlers are applied after the fields of route.
+func (c *Client) Call(ctx context.Context, route interface{}, result interface{}, handlers ...RequestHandler) (*Response, error) {
+	v := reflect.ValueOf(route)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errors.New("shttp: route is nil")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("shttp: route must be a struct, got %T", route)
+	}
+	info := routeInfoOf(v.Type())
+	if info.err != nil {
+		return nil, info.err
+	}
+	build, err := info.handler(v)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, info.method, c.resolveURL(info.path), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(c.handlerRequest(httpReq, append([]RequestHandler{build}, handlers...)...))
+	if err != nil {
+		return nil, err
+	}
+	if code := resp.resp.StatusCode; code < 200 || code >= 300 {
+		return resp, fmt.Errorf("shttp: %s response status %s", info.name, resp.resp.Status)
+	}
+	if result == nil {
+		return resp, nil
+	}
+	bs, err := resp.Bytes()
+	if err != nil {
+		return resp, err
+	}
+	if len(bs) > 0 {
+		err = json.Unmarshal(bs, result)
+	}
+	return resp, err
+}
+
+func routeInfoOf(t reflect.Type) *routeInfo {
+	if info, ok := routeInfos.Load(t); ok {
+		return info.(*routeInfo)
+	}
+	info := &routeInfo{name: t.Name()}
+	info.err = info.parse(t, nil)
+	if info.err == nil && info.path == "" {
+		info.err = fmt.Errorf("shttp: %s has no shttp.Route field with a path tag", info.name)
+	}
+	if info.err == nil {
+		info.err = info.checkPath()
+	}
+	actual, _ := routeInfos.LoadOrStore(t, info)
+	return actual.(*routeInfo)
+}
+
+func (info *routeInfo) parse(t reflect.Type, index []int) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		idx := append(append([]int(nil), index...), i)
+		if f.Type == routeType {
+			info.method = strings.ToUpper(f.Tag.Get("method"))
+			if info.method == "" {
+				info.method = GET.String()
+			}
+			info.path = f.Tag.Get("path")
+			continue
+		}
+		field, tagged, err := info.parseField(f, idx)
+		if err != nil {
+			return err
+		}
+		if tagged {
+			if field != nil {
+				info.fields = append(info.fields, *field)
+			}
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && ft.Kind() == reflect.Struct {
+			if err := info.parse(ft, idx); err != nil {
+				return err
+			}
+		}
+	}
+	var form, body bool
+	for _, f := range info.fields {
+		form = form || f.kind == routeForm
+		body = body || f.kind == routeJSON
+	}
+	if form && body {
+		return fmt.Errorf("shttp: %s has both form and json fields", info.name)
+	}
+	info.json = body
+	return nil
+}
+
+// parseField returns the field of the first route tag of f, tagged is false
+// when f has none and nil field when the tag is "-".
+func (info *routeInfo) parseField(f reflect.StructField, index []int) (field *routeField, tagged bool, err error) {
+	for kind, key := range routeTags {
+		tag, ok := f.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		if tag == "-" || f.PkgPath != "" {
+			return nil, true, nil
+		}
+		parts := strings.Split(tag, ",")
+		field = &routeField{index: index, name: parts[0], kind: kind}
+		if field.name == "" {
+			field.name = f.Name
+		}
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "omitempty":
+				field.omitEmpty = true
+			case "required":
+				field.required = true
+			}
+		}
+		if kind == routePath && isMultiValue(f.Type) {
+			return nil, true, &FieldError{Route: info.name, Field: f.Name, Reason: "path parameter can not be a slice"}
+		}
+		return field, true, nil
+	}
+	return nil, false, nil
+}
+
+// checkPath verifies that every placeholder of the path has a field.
+func (info *routeInfo) checkPath() error {
+	for _, m := range routePlaceholder.FindAllStringSubmatch(info.path, -1) {
+		found := false
+		for _, f := range info.fields {
+			if f.kind == routePath && f.name == m[1] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("shttp: %s has no path field for {%s}", info.name, m[1])
+		}
+	}
+	return nil
+}
+
+// handler validates the field values of v and returns the handler setting
+// them on the request.
+func (info *routeInfo) handler(v reflect.Value) (RequestHandler, error) {
+	type param struct {
+		field  *routeField
+		values []string
+	}
+	params := make([]param, 0, len(info.fields))
+	var body map[string]interface{}
+	for i := range info.fields {
+		f := &info.fields[i]
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || fv.IsZero() {
+			if f.required {
+				return nil, &FieldError{Route: info.name, Field: fieldName(v.Type(), f.index), Reason: "is required"}
+			}
+			if f.omitEmpty {
+				continue
+			}
+		}
+		if f.kind == routeJSON {
+			if body == nil {
+				body = map[string]interface{}{}
+			}
+			body[f.name] = nil
+			if ok {
+				body[f.name] = fv.Interface()
+			}
+			continue
+		}
+		p := param{field: f}
+		if ok {
+			values, err := formatValues(fv)
+			if err != nil {
+				return nil, &FieldError{Route: info.name, Field: fieldName(v.Type(), f.index), Reason: err.Error()}
+			}
+			p.values = values
+		}
+		params = append(params, p)
+	}
+	if body == nil && info.json {
+		body = map[string]interface{}{}
+	}
+	var bs []byte
+	if body != nil {
+		var err error
+		if bs, err = jsonMarshal(body); err != nil {
+			return nil, err
+		}
+	}
+	return func(c *Client, req *Request) {
+		for _, p := range params {
+			switch p.field.kind {
+			case routePath:
+				value := ""
+				if len(p.values) > 0 {
+					value = p.values[0]
+				}
+				req.PathParam(p.field.name, value)
+			case routeQuery:
+				for i, value := range p.values {
+					if i == 0 {
+						req.Query(p.field.name, value)
+					} else {
+						req.AddQuery(p.field.name, value)
+					}
+				}
+			case routeHeader:
+				for i, value := range p.values {
+					if i == 0 {
+						req.Header(p.field.name, value)
+					} else {
+						req.AddHeader(p.field.name, value)
+					}
+				}
+			case routeForm:
+				for _, value := range p.values {
+					req.AddPostForm(p.field.name, value)
+				}
+			}
+		}
+		if bs != nil {
+			req.BodyJSON4Bytes(bs)
+		}
+	}, nil
+}
+
+// fieldByIndex follows index through embedded pointers, ok is false when one
+// of them is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 {
+			if v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return reflect.Value{}, false
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func fieldName(t reflect.Type, index []int) string {
+	names := make([]string, 0, len(index))
+	for _, x := range index {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		f := t.Field(x)
+		names = append(names, f.Name)
+		t = f.Type
+	}
+	return strings.Join(names, ".")
+}
+
+func isMultiValue(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) || t.Kind() == reflect.Array
+}
+
+// formatValues formats a query, header, form or path value, a slice gives a
+// value per element.
+func formatValues(v reflect.Value) ([]string, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if isMultiValue(v.Type()) {
+		values := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s, err := formatValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	s, err := formatValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+func formatValue(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339), nil
+	}
+	if v.Type().Implements(textMarshaler) {
+		bs, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(bs), err
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), nil
+		}
+	}
+	return "", fmt.Errorf("has unsupported type %s", v.Type())
+}
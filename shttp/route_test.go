@@ -0,0 +1,139 @@
+package shttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+type routeAuth struct {
+	Token string `header:"Authorization,required"`
+}
+
+type getUser struct {
+	shttp.Route `method:"GET" path:"/users/{id}"`
+	routeAuth
+	ID     int64    `path:"id"`
+	Fields []string `query:"fields,omitempty"`
+	Limit  int      `query:"limit,omitempty"`
+	Active bool     `query:"active"`
+}
+
+type createUser struct {
+	shttp.Route `method:"POST" path:"/users"`
+	Name        string `json:"name,required"`
+	Email       string `json:"email,omitempty"`
+	Age         int    `json:"age"`
+}
+
+type loginForm struct {
+	shttp.Route `method:"post" path:"/login"`
+	User        string `form:"user,required"`
+	Password    string `form:"password"`
+	Remember    bool   `form:"remember,omitempty"`
+}
+
+type user struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func Test_Client_Call(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/users/7":
+			q := r.URL.Query()
+			if r.Method != http.MethodGet || r.Header.Get("Authorization") != "Bearer x" ||
+				strings.Join(q["fields"], ",") != "id,name" || q.Has("limit") || q.Get("active") != "false" {
+				t.Errorf("unexpected request %s %s %v", r.Method, r.URL, r.Header)
+			}
+			fmt.Fprint(w, `{"id":7,"name":"alice"}`)
+		case "/api/users":
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			if _, ok := body["email"]; ok || body["name"] != "bob" || body["age"] != float64(0) || r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("unexpected body %v", body)
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id":8,"name":"bob"}`)
+		case "/api/login":
+			if r.Method != http.MethodPost || r.FormValue("user") != "bob" || r.FormValue("password") != "" || r.Form.Has("remember") {
+				t.Errorf("unexpected form %v", r.Form)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	c := shttp.New(shttp.WithBaseURL(srv.URL + "/api"))
+	var u user
+	_, err := c.Call(context.Background(), &getUser{routeAuth: routeAuth{Token: "Bearer x"}, ID: 7, Fields: []string{"id", "name"}}, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 7 || u.Name != "alice" {
+		t.Fatalf("unexpected user %+v", u)
+	}
+
+	if _, err := c.Call(context.Background(), createUser{Name: "bob"}, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 8 {
+		t.Fatalf("unexpected user %+v", u)
+	}
+
+	resp, err := c.Call(context.Background(), loginForm{User: "bob"}, nil)
+	if err == nil || resp == nil || resp.Response().StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected response %v", err)
+	}
+}
+
+func Test_Client_Call_Validation(t *testing.T) {
+	c := shttp.New(shttp.WithBaseURL("http://127.0.0.1:1"))
+
+	_, err := c.Call(context.Background(), getUser{ID: 1}, nil)
+	fieldErr, ok := err.(*shttp.FieldError)
+	if !ok || fieldErr.Field != "routeAuth.Token" || fieldErr.Route != "getUser" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := c.Call(context.Background(), createUser{}, nil); err == nil {
+		t.Fatal("expected required json field error")
+	}
+
+	type missingPath struct {
+		shttp.Route `path:"/users/{id}"`
+	}
+	if _, err := c.Call(context.Background(), missingPath{}, nil); err == nil || !strings.Contains(err.Error(), "{id}") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	type mixed struct {
+		shttp.Route `method:"POST" path:"/x"`
+		A           string `form:"a"`
+		B           string `json:"b"`
+	}
+	if _, err := c.Call(context.Background(), mixed{}, nil); err == nil {
+		t.Fatal("expected form and json error")
+	}
+	if _, err := c.Call(context.Background(), struct{ A string }{}, nil); err == nil {
+		t.Fatal("expected missing route error")
+	}
+}
+
+func Test_Client_Call_PathDotSegment(t *testing.T) {
+	type getFile struct {
+		shttp.Route `path:"/files/{name}"`
+		Name        string `path:"name"`
+	}
+	c := shttp.New(shttp.WithBaseURL("http://127.0.0.1:1"))
+	if _, err := c.Call(context.Background(), getFile{Name: ".."}, nil); err == nil || !strings.Contains(err.Error(), "..") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
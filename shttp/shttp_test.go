@@ -11,7 +11,7 @@ import (
 func Test_Client_Get(t *testing.T) {
 	client := shttp.New(shttp.WithMiddleware(func(c *shttp.Client, req *shttp.Request, resp *shttp.Response) error {
 		p, _ := url.Parse("http://127.0.0.1:9900")
-		c.ProxyUrl(p)
+		req.Proxy(p)
 		return nil
 	}))
 	resp, err := client.Post("http://baidu.com", func(c *shttp.Client, req *shttp.Request) {
@@ -35,7 +35,7 @@ func Test_DefaultClient_Get(t *testing.T) {
 		req.Query("test", "1")
 
 		p, _ := url.Parse("http://127.0.0.1:9900")
-		c.ProxyUrl(p)
+		req.Proxy(p)
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -49,7 +49,7 @@ func Test_DefaultClient_Post(t *testing.T) {
 		req.Query("test", "1")
 		req.PostForm("test", "2")
 		p, _ := url.Parse("http://127.0.0.1:9900")
-		c.ProxyUrl(p)
+		req.Proxy(p)
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,252 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	httpHeaderAccept         = `Accept`
+	httpHeaderLastEventID    = `Last-Event-ID`
+	httpHeaderContentTypeSSE = `text/event-stream`
+
+	defaultEventStreamRetry = 3 * time.Second
+)
+
+// ErrEventStreamClosed is returned when the server closes the stream with
+// 204 No Content, which means the client must not reconnect.
+var ErrEventStreamClosed = errors.New("event stream closed by server")
+
+// Event is a single Server-Sent Event. Retry is the reconnect delay in
+// effect when the event was dispatched.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// EventStream consumes a `text/event-stream` endpoint and reconnects with
+// `Last-Event-ID` when the connection drops.
+type EventStream struct {
+	c        *Client
+	url      string
+	method   Method
+	handlers []RequestHandler
+
+	retry         time.Duration
+	maxReconnects int
+	lastEventID   string
+}
+
+// EventStream returns a Server-Sent Events consumer for url.
+func (c *Client) EventStream(url string, handlers ...RequestHandler) *EventStream {
+	return &EventStream{
+		c:        c,
+		url:      url,
+		method:   GET,
+		handlers: handlers,
+		retry:    defaultEventStreamRetry,
+	}
+}
+
+// Method sets the HTTP method of the stream request, GET by default.
+func (s *EventStream) Method(method Method) *EventStream {
+	s.method = method
+	return s
+}
+
+// Retry sets the reconnect delay used until the server sends a `retry` field.
+func (s *EventStream) Retry(d time.Duration) *EventStream {
+	s.retry = d
+	return s
+}
+
+// MaxReconnects limits the number of consecutive reconnects, zero means no limit.
+func (s *EventStream) MaxReconnects(n int) *EventStream {
+	s.maxReconnects = n
+	return s
+}
+
+// LastEventID sets the id sent with the first connection.
+func (s *EventStream) LastEventID(id string) *EventStream {
+	s.lastEventID = id
+	return s
+}
+
+// Subscribe calls fn for every event until ctx is done, fn returns an error
+// or the stream fails permanently. It returns ctx.Err() on cancellation.
+func (s *EventStream) Subscribe(ctx context.Context, fn func(e *Event) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	reconnects := 0
+	for {
+		received, err := s.connect(ctx, fn)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var fnErr *eventHandlerError
+		if errors.As(err, &fnErr) {
+			return fnErr.err
+		}
+		if errors.Is(err, ErrEventStreamClosed) || errors.Is(err, errEventStreamStatus) {
+			return err
+		}
+		if received {
+			reconnects = 0
+		}
+		reconnects++
+		if s.maxReconnects > 0 && reconnects > s.maxReconnects {
+			if err == nil {
+				err = io.EOF
+			}
+			return fmt.Errorf("event stream: giving up after %d reconnects: %w", s.maxReconnects, err)
+		}
+		t := time.NewTimer(s.retry)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Events delivers the events through a channel. The error channel receives
+// the error that ended the stream, then both channels are closed.
+func (s *EventStream) Events(ctx context.Context) (<-chan *Event, <-chan error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	events := make(chan *Event)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(events)
+		defer close(errc)
+		errc <- s.Subscribe(ctx, func(e *Event) error {
+			select {
+			case events <- e:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return events, errc
+}
+
+var errEventStreamStatus = errors.New("unexpected event stream response")
+
+type eventHandlerError struct {
+	err error
+}
+
+func (e *eventHandlerError) Error() string {
+	return e.err.Error()
+}
+
+// connect reads one connection until it ends. received reports whether at
+// least one event was dispatched.
+func (s *EventStream) connect(ctx context.Context, fn func(e *Event) error) (received bool, err error) {
+	handlers := make([]RequestHandler, 0, len(s.handlers)+2)
+	handlers = append(handlers, func(c *Client, req *Request) {
+		req.WithContext(ctx)
+	})
+	handlers = append(handlers, s.handlers...)
+	handlers = append(handlers, func(c *Client, req *Request) {
+		req.Header(httpHeaderAccept, httpHeaderContentTypeSSE)
+		req.Header("Cache-Control", "no-cache")
+		if s.lastEventID != "" {
+			req.Header(httpHeaderLastEventID, s.lastEventID)
+		}
+	})
+	httpReq, err := http.NewRequest(s.method.String(), s.c.resolveURL(s.url), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.c.do(s.c.handlerRequest(httpReq, handlers...), (*Client).httpClient, true)
+	if err != nil {
+		return false, err
+	}
+	body := resp.Response().Body
+	defer body.Close()
+
+	switch code := resp.Response().StatusCode; {
+	case code == http.StatusNoContent:
+		return false, ErrEventStreamClosed
+	case code != http.StatusOK:
+		return false, fmt.Errorf("%w: %s", errEventStreamStatus, resp.Response().Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Response().Header.Get(httpHeaderContentType))
+	if mediaType != httpHeaderContentTypeSSE {
+		return false, fmt.Errorf("%w: content type %q", errEventStreamStatus, mediaType)
+	}
+
+	var (
+		r         = bufio.NewReader(body)
+		data      strings.Builder
+		eventType string
+		hasData   bool
+	)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return received, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line == "" {
+			if hasData {
+				e := &Event{
+					ID:    s.lastEventID,
+					Event: eventType,
+					Data:  strings.TrimSuffix(data.String(), "\n"),
+					Retry: s.retry,
+				}
+				if e.Event == "" {
+					e.Event = "message"
+				}
+				received = true
+				if err := fn(e); err != nil {
+					return received, &eventHandlerError{err: err}
+				}
+			}
+			data.Reset()
+			eventType, hasData = "", false
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				s.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package shttp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_EventStream_Reconnect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if r.Header.Get("Last-Event-ID") == "" {
+			fmt.Fprint(w, "retry: 10\n: comment\nid: 1\nevent: greet\ndata: hello\ndata: world\n\n")
+			return
+		}
+		fmt.Fprintf(w, "id: 2\ndata: after %s\n\n", r.Header.Get("Last-Event-ID"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, errc := shttp.New().EventStream(srv.URL).Events(ctx)
+
+	e := <-events
+	if e.ID != "1" || e.Event != "greet" || e.Data != "hello\nworld" || e.Retry != 10*time.Millisecond {
+		t.Fatalf("unexpected first event %+v", e)
+	}
+	e = <-events
+	if e.ID != "2" || e.Event != "message" || e.Data != "after 1" {
+		t.Fatalf("unexpected second event %+v", e)
+	}
+	cancel()
+	for range events {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Fatal(err)
+	}
+}
+
+func Test_EventStream_ClosedByServer(t *testing.T) {
+	var connections int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&connections, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	err := shttp.New().EventStream(srv.URL).Retry(time.Millisecond).Subscribe(context.Background(), func(e *shttp.Event) error {
+		return nil
+	})
+	if !errors.Is(err, shttp.ErrEventStreamClosed) {
+		t.Fatalf("expected ErrEventStreamClosed, got %v", err)
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Fatalf("reconnected after 204, %d connections", n)
+	}
+}
+
+func Test_EventStream_MaxReconnects(t *testing.T) {
+	var connections int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&connections, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer srv.Close()
+
+	err := shttp.New().EventStream(srv.URL).Retry(time.Millisecond).MaxReconnects(2).Subscribe(context.Background(), func(e *shttp.Event) error {
+		return nil
+	})
+	if !errors.Is(err, io.EOF) || !strings.Contains(err.Error(), "giving up after 2 reconnects") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n := atomic.LoadInt32(&connections); n != 3 {
+		t.Fatalf("expected 3 connections, got %d", n)
+	}
+}
+
+func Test_EventStream_ContentType(t *testing.T) {
+	var connections int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&connections, 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "data: not an event\n\n")
+	}))
+	defer srv.Close()
+
+	called := false
+	err := shttp.New().EventStream(srv.URL).Retry(time.Millisecond).Subscribe(context.Background(), func(e *shttp.Event) error {
+		called = true
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), `content type "text/html"`) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if called || atomic.LoadInt32(&connections) != 1 {
+		t.Fatalf("non event-stream response consumed, %d connections", atomic.LoadInt32(&connections))
+	}
+}
+
+func Test_EventStream_UnterminatedEvent(t *testing.T) {
+	var connections int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&connections, 1) > 1 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: complete\n\ndata: partial")
+	}))
+	defer srv.Close()
+
+	var data []string
+	err := shttp.New().EventStream(srv.URL).Retry(time.Millisecond).Subscribe(context.Background(), func(e *shttp.Event) error {
+		data = append(data, e.Data)
+		return nil
+	})
+	if !errors.Is(err, shttp.ErrEventStreamClosed) {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0] != "complete" {
+		t.Fatalf("unexpected events %q", data)
+	}
+}
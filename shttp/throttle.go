@@ -0,0 +1,175 @@
+package shttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket of bytes per second. One limiter shared by
+// several requests or clients caps their transfers together.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter of bytesPerSec, bursts of a tenth of a
+// second pass at once. A bytesPerSec of zero or less does not limit.
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimit(bytesPerSec)
+	l.tokens = float64(l.burst)
+	return l
+}
+
+// SetLimit changes the rate, also for the transfers in progress. Zero or
+// less removes the limit.
+func (l *RateLimiter) SetLimit(bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		l.mu.Lock()
+		l.rate, l.burst = 0, 0
+		l.mu.Unlock()
+		return
+	}
+	burst := bytesPerSec / 10
+	if burst < 512 {
+		burst = 512
+	}
+	if burst > bytesPerSec {
+		burst = bytesPerSec
+	}
+	l.mu.Lock()
+	l.rate, l.burst = float64(bytesPerSec), int(burst)
+	l.mu.Unlock()
+}
+
+// wait takes n bytes from the bucket and blocks until they are available.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk returns the largest read passed at once, zero when unlimited.
+func (l *RateLimiter) chunk() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.burst
+}
+
+// WithUploadLimit throttles the request bodies with l, see RateLimiter. A
+// nil l removes the limit.
+func WithUploadLimit(l *RateLimiter) Option {
+	return func(c *Client) {
+		c.uploadLimit = l
+	}
+}
+
+// WithDownloadLimit throttles the response bodies with l, see RateLimiter.
+// A nil l removes the limit.
+func WithDownloadLimit(l *RateLimiter) Option {
+	return func(c *Client) {
+		c.downloadLimit = l
+	}
+}
+
+// UploadLimit throttles the body of this request with l in addition to the
+// client limit.
+func (r *Request) UploadLimit(l *RateLimiter) {
+	r.uploadLimit = l
+}
+
+// DownloadLimit throttles the response body of this request with l in
+// addition to the client limit.
+func (r *Request) DownloadLimit(l *RateLimiter) {
+	r.downloadLimit = l
+}
+
+func limiters(ls ...*RateLimiter) []*RateLimiter {
+	active := make([]*RateLimiter, 0, len(ls))
+	for _, l := range ls {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+	return active
+}
+
+// throttleRequest throttles the body of httpReq, also when it is replayed.
+func throttleRequest(httpReq *http.Request, ls []*RateLimiter) {
+	if len(ls) == 0 || httpReq.Body == nil || httpReq.Body == http.NoBody {
+		return
+	}
+	ctx := httpReq.Context()
+	httpReq.Body = &throttledBody{rc: httpReq.Body, ctx: ctx, limiters: ls}
+	if getBody := httpReq.GetBody; getBody != nil {
+		httpReq.GetBody = func() (io.ReadCloser, error) {
+			rc, err := getBody()
+			if err != nil || rc == http.NoBody {
+				return rc, err
+			}
+			return &throttledBody{rc: rc, ctx: ctx, limiters: ls}, nil
+		}
+	}
+}
+
+// throttledBody reads at most a burst at once and waits for the bytes read
+// on every limiter.
+type throttledBody struct {
+	rc       io.ReadCloser
+	ctx      context.Context
+	limiters []*RateLimiter
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	for _, l := range b.limiters {
+		if c := l.chunk(); c > 0 && len(p) > c {
+			p = p[:c]
+		}
+	}
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		for _, l := range b.limiters {
+			if werr := l.wait(b.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+func (b *throttledBody) Close() error {
+	return b.rc.Close()
+}
@@ -0,0 +1,89 @@
+package shttp_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_Throttle(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 32<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			n, _ := io.Copy(io.Discard, r.Body)
+			if n != int64(len(payload)) {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			return
+		}
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	// two concurrent downloads share the cap of 128 KiB/s
+	client := shttp.New(shttp.WithDownloadLimit(shttp.NewRateLimiter(128 << 10)))
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body, err := client.GetToBytes(srv.URL); err != nil || len(body) != len(payload) {
+				t.Errorf("download failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Fatalf("downloads not throttled, took %s", d)
+	}
+
+	start = time.Now()
+	resp, err := shttp.New().Post(srv.URL, func(c *shttp.Client, req *shttp.Request) {
+		req.BodyReader(bytes.NewReader(payload))
+		req.UploadLimit(shttp.NewRateLimiter(64 << 10))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Ok() {
+		t.Fatalf("upload truncated, status %d", resp.Response().StatusCode)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Fatalf("upload not throttled, took %s", d)
+	}
+}
+
+func Test_RateLimiter_Unlimited(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 1<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	limiter := shttp.NewRateLimiter(0)
+	client := shttp.New(shttp.WithDownloadLimit(limiter))
+	start := time.Now()
+	if body, err := client.GetToBytes(srv.URL); err != nil || len(body) != len(payload) {
+		t.Fatalf("download failed: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("zero limit throttled, took %s", d)
+	}
+
+	limiter.SetLimit(1)
+	limiter.SetLimit(-1)
+	start = time.Now()
+	if body, err := client.GetToBytes(srv.URL); err != nil || len(body) != len(payload) {
+		t.Fatalf("download failed: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("negative limit throttled, took %s", d)
+	}
+}
@@ -0,0 +1,246 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Timeout phases reported by TimeoutError.
+const (
+	PhaseResponseHeader = "response header"
+	PhaseIdleRead       = "idle read"
+	PhaseRequest        = "request"
+)
+
+// Timeouts are the per-phase timeouts of a request, a zero value disables
+// the phase.
+type Timeouts struct {
+	// Dial bounds establishing the TCP or unix connection.
+	Dial time.Duration
+	// TLSHandshake bounds the TLS handshake. It is a client setting of the
+	// transport and is ignored on a request.
+	TLSHandshake time.Duration
+	// ResponseHeader bounds the time from sending the request, including
+	// the connection setup, to receiving the final response headers.
+	ResponseHeader time.Duration
+	// IdleRead bounds the time between two successful reads of the response
+	// body, the timer is reset on every progress so long downloads are fine.
+	IdleRead time.Duration
+	// Request bounds the whole request including reading the body.
+	Request time.Duration
+}
+
+// DefaultTimeouts are the timeouts of a client created by New.
+var DefaultTimeouts = Timeouts{
+	Dial:           5 * time.Second,
+	TLSHandshake:   10 * time.Second,
+	ResponseHeader: 30 * time.Second,
+	IdleRead:       30 * time.Second,
+}
+
+// TimeoutError is returned when a phase of the request timed out.
+type TimeoutError struct {
+	Phase string
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout after %s", e.Phase, e.After)
+}
+
+// Timeout reports that the error is a timeout, like net.Error.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// WithTimeouts sets the per-phase timeouts of the client.
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(c *Client) {
+		c.Timeouts(timeouts)
+	}
+}
+
+// Timeouts sets the per-phase timeouts of the client.
+//
+// Deprecated: use WithTimeouts, see Client.
+func (c *Client) Timeouts(timeouts Timeouts) {
+	if c.built {
+		return
+	}
+	c.timeouts = timeouts
+	t := c.transport()
+	t.DialContext = c.dialContext
+	t.TLSHandshakeTimeout = timeouts.TLSHandshake
+}
+
+// Timeout sets the dial timeout and the idle read timeout of the body, the
+// other phases keep their values. See Timeouts.
+//
+// Deprecated: use WithTimeouts, see Client.
+func (c *Client) Timeout(connectTimeout time.Duration, readWriteTimeout time.Duration) {
+	if c.built {
+		return
+	}
+	timeouts := c.timeouts
+	timeouts.Dial = connectTimeout
+	timeouts.IdleRead = readWriteTimeout
+	c.Timeouts(timeouts)
+}
+
+// Timeouts overrides the timeouts of the client for this request, zero
+// fields keep the client value.
+func (r *Request) Timeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+// Timeout bounds the whole request, see Timeouts.Request.
+func (r *Request) Timeout(d time.Duration) {
+	r.timeouts.Request = d
+}
+
+type dialTimeoutKey struct{}
+
+// requestTimeouts merges the request overrides into the client timeouts. A
+// stream only gets the phases set on the request and the connection setup
+// phases of the client, its body may be idle or open indefinitely.
+func (c *Client) requestTimeouts(req *Request, stream bool) Timeouts {
+	t := c.timeouts
+	if stream {
+		t.IdleRead, t.Request = 0, 0
+	}
+	o := req.timeouts
+	if o.Dial > 0 {
+		t.Dial = o.Dial
+	}
+	if o.ResponseHeader > 0 {
+		t.ResponseHeader = o.ResponseHeader
+	}
+	if o.IdleRead > 0 {
+		t.IdleRead = o.IdleRead
+	}
+	if o.Request > 0 {
+		t.Request = o.Request
+	}
+	return t
+}
+
+// send runs the request with the response header, idle read and request
+// timeouts of to. The request context is released when the body is closed.
+func send(hc *http.Client, httpReq *http.Request, to Timeouts) (*http.Response, error) {
+	if to.Dial > 0 {
+		httpReq = httpReq.WithContext(context.WithValue(httpReq.Context(), dialTimeoutKey{}, to.Dial))
+	}
+	if to.ResponseHeader <= 0 && to.IdleRead <= 0 && to.Request <= 0 {
+		return hc.Do(httpReq)
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if to.Request > 0 {
+		ctx, cancel = context.WithTimeout(httpReq.Context(), to.Request)
+	} else {
+		ctx, cancel = context.WithCancel(httpReq.Context())
+	}
+	var headerExpired int32
+	var headerTimer *time.Timer
+	if to.ResponseHeader > 0 {
+		headerTimer = time.AfterFunc(to.ResponseHeader, func() {
+			atomic.StoreInt32(&headerExpired, 1)
+			cancel()
+		})
+	}
+	resp, err := hc.Do(httpReq.WithContext(ctx))
+	if headerTimer != nil {
+		headerTimer.Stop()
+	}
+	if err != nil {
+		cancel()
+		switch {
+		case atomic.LoadInt32(&headerExpired) == 1:
+			return nil, &TimeoutError{Phase: PhaseResponseHeader, After: to.ResponseHeader}
+		case to.Request > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) && httpReq.Context().Err() == nil:
+			return nil, &TimeoutError{Phase: PhaseRequest, After: to.Request}
+		}
+		return nil, err
+	}
+	if rwc, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		// the upgraded connection belongs to the caller, keep it writable
+		resp.Body = &upgradedBody{ReadWriteCloser: rwc, cancel: cancel}
+		return resp, nil
+	}
+	body := &timeoutBody{
+		rc:     resp.Body,
+		ctx:    ctx,
+		parent: httpReq.Context(),
+		cancel: cancel,
+		to:     to,
+	}
+	if to.IdleRead > 0 {
+		body.timer = time.AfterFunc(to.IdleRead, func() {
+			atomic.StoreInt32(&body.idleExpired, 1)
+			cancel()
+		})
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// timeoutBody enforces the idle read and request timeouts on a body.
+type timeoutBody struct {
+	rc     io.ReadCloser
+	ctx    context.Context
+	parent context.Context
+	cancel context.CancelFunc
+	to     Timeouts
+
+	timer       *time.Timer
+	idleExpired int32
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 && b.timer != nil && atomic.LoadInt32(&b.idleExpired) == 0 {
+		b.timer.Reset(b.to.IdleRead)
+	}
+	if err != nil && err != io.EOF {
+		switch {
+		case atomic.LoadInt32(&b.idleExpired) == 1:
+			err = &TimeoutError{Phase: PhaseIdleRead, After: b.to.IdleRead}
+		case b.to.Request > 0 && errors.Is(b.ctx.Err(), context.DeadlineExceeded) && b.parent.Err() == nil:
+			err = &TimeoutError{Phase: PhaseRequest, After: b.to.Request}
+		}
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.rc.Close()
+	b.cancel()
+	return err
+}
+
+// upgradedBody releases the request context when the connection is closed.
+type upgradedBody struct {
+	io.ReadWriteCloser
+	cancel context.CancelFunc
+}
+
+func (b *upgradedBody) Close() error {
+	err := b.ReadWriteCloser.Close()
+	b.cancel()
+	return err
+}
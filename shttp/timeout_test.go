@@ -0,0 +1,54 @@
+package shttp_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func Test_Client_Timeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-header":
+			time.Sleep(200 * time.Millisecond)
+		case "/trickle":
+			for i := 0; i < 5; i++ {
+				w.Write([]byte("x"))
+				w.(http.Flusher).Flush()
+				time.Sleep(40 * time.Millisecond)
+			}
+		case "/stall":
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	client := shttp.New(shttp.WithTimeouts(shttp.Timeouts{
+		Dial:           time.Second,
+		ResponseHeader: 100 * time.Millisecond,
+		IdleRead:       100 * time.Millisecond,
+	}))
+
+	var te *shttp.TimeoutError
+	if _, err := client.Get(srv.URL + "/slow-header"); !errors.As(err, &te) || te.Phase != shttp.PhaseResponseHeader {
+		t.Fatalf("expected response header timeout, got %v", err)
+	}
+	if body, err := client.GetToString(srv.URL + "/trickle"); err != nil || body != "xxxxx" {
+		t.Fatalf("idle timer not reset on progress: %q %v", body, err)
+	}
+	if _, err := client.GetToString(srv.URL + "/stall"); !errors.As(err, &te) || te.Phase != shttp.PhaseIdleRead {
+		t.Fatalf("expected idle read timeout, got %v", err)
+	}
+	_, err := client.GetToString(srv.URL+"/trickle", func(c *shttp.Client, req *shttp.Request) {
+		req.Timeout(50 * time.Millisecond)
+	})
+	if !errors.As(err, &te) || te.Phase != shttp.PhaseRequest {
+		t.Fatalf("expected request timeout, got %v", err)
+	}
+}
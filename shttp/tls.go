@@ -0,0 +1,283 @@
+package shttp
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// WithInsecureSkipVerify disables the verification of the server
+// certificate chain and host name. Pinned keys are still checked.
+func WithInsecureSkipVerify() Option {
+	return func(c *Client) {
+		c.InsecureSkipVerify(true)
+	}
+}
+
+// WithClientCertificate loads the PEM encoded certificate and key files for
+// mutual TLS.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(c *Client) {
+		c.setErr(c.ClientCertificate(certFile, keyFile))
+	}
+}
+
+// WithClientCertificatePEM uses the PEM encoded certificate and key for
+// mutual TLS.
+func WithClientCertificatePEM(certPEM, keyPEM []byte) Option {
+	return func(c *Client) {
+		c.setErr(c.ClientCertificatePEM(certPEM, keyPEM))
+	}
+}
+
+// WithRootCAFile trusts the PEM encoded certificates of the file in
+// addition to the system roots.
+func WithRootCAFile(file string) Option {
+	return func(c *Client) {
+		c.setErr(c.RootCAFile(file))
+	}
+}
+
+// WithRootCA trusts the PEM encoded certificates in addition to the system
+// roots.
+func WithRootCA(pem []byte) Option {
+	return func(c *Client) {
+		c.setErr(c.RootCA(pem))
+	}
+}
+
+// WithPinnedPublicKeys only accepts servers whose chain contains one of the
+// public keys, see Client.PinnedPublicKeys.
+func WithPinnedPublicKeys(pins ...string) Option {
+	return func(c *Client) {
+		c.setErr(c.PinnedPublicKeys(pins...))
+	}
+}
+
+// WithTLSMinVersion sets the minimum TLS version, TLS 1.2 by default.
+func WithTLSMinVersion(version uint16) Option {
+	return func(c *Client) {
+		c.tlsConfig().MinVersion = version
+	}
+}
+
+// WithTLSCipherSuites restricts the TLS 1.0-1.2 cipher suites, TLS 1.3
+// suites are not configurable.
+func WithTLSCipherSuites(suites ...uint16) Option {
+	return func(c *Client) {
+		c.tlsConfig().CipherSuites = suites
+	}
+}
+
+// WithTLSClientConfig uses a copy of cfg as the TLS config, see
+// Client.TLSClientConfig.
+func WithTLSClientConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.TLSClientConfig(cfg)
+	}
+}
+
+// TLSClientConfig replaces the TLS config with a copy of cfg, the TLS
+// options that follow change the copy.
+//
+// Deprecated: use WithTLSClientConfig, see Client.
+func (c *Client) TLSClientConfig(cfg *tls.Config) {
+	if c.built {
+		return
+	}
+	if cfg != nil {
+		cfg = cfg.Clone()
+	}
+	c.transport().TLSClientConfig = cfg
+}
+
+// TLSConfig sends this request with cfg instead of the TLS config of the
+// client. The connections of a config are pooled apart from the client's,
+// reuse the same cfg for the requests that share it. The pools of the
+// maxTLSClients most recently used configs are kept.
+func (r *Request) TLSConfig(cfg *tls.Config) {
+	r.tlsConfig = cfg
+}
+
+// maxTLSClients bounds the clients of per-request TLS configs, each has its
+// own transport and connection pool.
+const maxTLSClients = 16
+
+type tlsClient struct {
+	cfg *tls.Config
+	c   *Client
+}
+
+// tlsClient returns the clone of c that uses cfg, sharing the connection
+// statistics of c. The least recently used clone is evicted and its idle
+// connections closed when there are more than maxTLSClients.
+func (c *Client) tlsClient(cfg *tls.Config) *Client {
+	c.tlsMu.Lock()
+	defer c.tlsMu.Unlock()
+	if e, ok := c.tlsClients[cfg]; ok {
+		c.tlsLRU.MoveToFront(e)
+		return e.Value.(*tlsClient).c
+	}
+	tc := c.Clone(WithTLSClientConfig(cfg))
+	tc.conns = c.conns
+	if c.tlsClients == nil {
+		c.tlsClients = make(map[*tls.Config]*list.Element)
+		c.tlsLRU = list.New()
+	}
+	c.tlsClients[cfg] = c.tlsLRU.PushFront(&tlsClient{cfg: cfg, c: tc})
+	if c.tlsLRU.Len() > maxTLSClients {
+		oldest := c.tlsLRU.Remove(c.tlsLRU.Back()).(*tlsClient)
+		delete(c.tlsClients, oldest.cfg)
+		closeIdleConnections(oldest.c.c.Transport)
+	}
+	return tc
+}
+
+// InsecureSkipVerify turns the verification of the server certificate off
+// or back on.
+//
+// Deprecated: use WithInsecureSkipVerify, see Client.
+func (c *Client) InsecureSkipVerify(skip bool) {
+	if c.built {
+		return
+	}
+	c.tlsConfig().InsecureSkipVerify = skip
+}
+
+// ClientCertificate loads the PEM encoded certificate and key files for
+// mutual TLS.
+//
+// Deprecated: use WithClientCertificate, see Client.
+func (c *Client) ClientCertificate(certFile, keyFile string) error {
+	if c.built {
+		return ErrClientBuilt
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cfg := c.tlsConfig()
+	cfg.Certificates = append(cfg.Certificates, cert)
+	return nil
+}
+
+// ClientCertificatePEM uses the PEM encoded certificate and key for mutual TLS.
+//
+// Deprecated: use WithClientCertificatePEM, see Client.
+func (c *Client) ClientCertificatePEM(certPEM, keyPEM []byte) error {
+	if c.built {
+		return ErrClientBuilt
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	cfg := c.tlsConfig()
+	cfg.Certificates = append(cfg.Certificates, cert)
+	return nil
+}
+
+// RootCAFile trusts the PEM encoded certificates of the file in addition to
+// the system roots.
+//
+// Deprecated: use WithRootCAFile, see Client.
+func (c *Client) RootCAFile(file string) error {
+	if c.built {
+		return ErrClientBuilt
+	}
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return c.RootCA(pem)
+}
+
+// RootCA trusts the PEM encoded certificates in addition to the system roots.
+//
+// Deprecated: use WithRootCA, see Client.
+func (c *Client) RootCA(pem []byte) error {
+	if c.built {
+		return ErrClientBuilt
+	}
+	cfg := c.tlsConfig()
+	if cfg.RootCAs == nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		cfg.RootCAs = pool
+	}
+	if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+		return errors.New("no certificate found in root CA pem")
+	}
+	return nil
+}
+
+// PinnedPublicKeys only accepts servers whose verified chain contains one of
+// the public keys. With InsecureSkipVerify there is no verified chain and
+// the key of the server certificate must be pinned. A pin is the base64
+// SHA-256 of the DER encoded SubjectPublicKeyInfo, optionally prefixed with
+// "sha256/", as produced by
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// Deprecated: use WithPinnedPublicKeys, see Client.
+func (c *Client) PinnedPublicKeys(pins ...string) error {
+	if c.built {
+		return ErrClientBuilt
+	}
+	hashes := make(map[[sha256.Size]byte]bool, len(pins))
+	for _, pin := range pins {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid public key pin %q", pin)
+		}
+		var h [sha256.Size]byte
+		copy(h[:], b)
+		hashes[h] = true
+	}
+	cfg := c.tlsConfig()
+	if len(hashes) == 0 {
+		cfg.VerifyConnection = nil
+		return nil
+	}
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		// the certificates sent by the server are not trusted, a pinned key
+		// appended to them must not pass
+		chains := cs.VerifiedChains
+		if len(chains) == 0 && len(cs.PeerCertificates) > 0 {
+			// InsecureSkipVerify, only the leaf is bound to the connection
+			chains = [][]*x509.Certificate{cs.PeerCertificates[:1]}
+		}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if hashes[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+					return nil
+				}
+			}
+		}
+		return errors.New("tls: no pinned public key in the server certificate chain")
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS config of the transport, creating it if needed.
+func (c *Client) tlsConfig() *tls.Config {
+	t := c.transport()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return t.TLSClientConfig
+}
+
+func (c *Client) setErr(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
@@ -0,0 +1,119 @@
+package shttp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+func serverPEM(srv *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func Test_Client_TLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	spki := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	pin := "sha256/" + base64.StdEncoding.EncodeToString(spki[:])
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	if _, err := shttp.New().Get(srv.URL); err == nil {
+		t.Fatal("unverified certificate accepted by default")
+	}
+	if _, err := shttp.New(shttp.WithInsecureSkipVerify()).Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shttp.New(shttp.WithRootCA(serverPEM(srv)), shttp.WithPinnedPublicKeys(pin)).Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shttp.New(shttp.WithInsecureSkipVerify(), shttp.WithPinnedPublicKeys(otherPin)).Get(srv.URL); err == nil {
+		t.Fatal("unpinned public key accepted")
+	}
+	if _, err := shttp.New(shttp.WithRootCAFile("testdata/missing.pem")).Get(srv.URL); err == nil {
+		t.Fatal("option error not returned")
+	}
+}
+
+// testCert creates a certificate for 127.0.0.1 signed by parent, self-signed
+// when parent is nil.
+func testCert(t *testing.T, parent *tls.Certificate, ca bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "shttp test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func Test_Client_TLS_PinAppendedCertificate(t *testing.T) {
+	ca := testCert(t, nil, true)
+	leaf := testCert(t, &ca, false)
+	pinned := testCert(t, nil, false)
+	// a trusted but not pinned leaf followed by the pinned certificate
+	leaf.Certificate = append(leaf.Certificate, pinned.Certificate[0])
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{leaf}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	spki := sha256.Sum256(pinned.Leaf.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(spki[:])
+	caSPKI := sha256.Sum256(ca.Leaf.RawSubjectPublicKeyInfo)
+	caPin := base64.StdEncoding.EncodeToString(caSPKI[:])
+
+	if _, err := shttp.New(shttp.WithRootCA(caPEM)).Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shttp.New(shttp.WithRootCA(caPEM), shttp.WithPinnedPublicKeys(pin)).Get(srv.URL); err == nil {
+		t.Fatal("pinned certificate appended to the chain accepted")
+	}
+	if _, err := shttp.New(shttp.WithInsecureSkipVerify(), shttp.WithPinnedPublicKeys(pin)).Get(srv.URL); err == nil {
+		t.Fatal("pinned certificate appended to the chain accepted without verification")
+	}
+	if _, err := shttp.New(shttp.WithRootCA(caPEM), shttp.WithPinnedPublicKeys(caPin)).Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+}
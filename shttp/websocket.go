@@ -0,0 +1,645 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket message types, the values are the RFC 6455 opcodes.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+
+	websocketGUID      = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	defaultReadLimit   = 32 << 20
+	maxControlFrameLen = 125
+)
+
+// WebSocket close codes.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseAbnormalClosure  = 1006
+	CloseInvalidPayload   = 1007
+	CloseMessageTooBig    = 1009
+)
+
+var ErrWebSocketClosed = errors.New("websocket: use of closed connection")
+
+// CloseError is returned by ReadMessage when the peer closes the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// WebSocketDialer opens WebSocket connections through the transport of a
+// Client, so proxy, TLS, dial, cookie jar and middleware settings apply to
+// the handshake.
+type WebSocketDialer struct {
+	c        *Client
+	url      string
+	handlers []RequestHandler
+
+	subprotocols  []string
+	pingInterval  time.Duration
+	readLimit     int64
+	fragmentSize  int
+	retry         time.Duration
+	maxRetry      time.Duration
+	maxReconnects int
+}
+
+// WebSocket returns a dialer for a ws://, wss://, http:// or https:// url.
+func (c *Client) WebSocket(url string, handlers ...RequestHandler) *WebSocketDialer {
+	return &WebSocketDialer{
+		c:         c,
+		url:       url,
+		handlers:  handlers,
+		readLimit: defaultReadLimit,
+		retry:     time.Second,
+		maxRetry:  30 * time.Second,
+	}
+}
+
+// Subprotocols sets the requested `Sec-WebSocket-Protocol` values.
+func (d *WebSocketDialer) Subprotocols(protocols ...string) *WebSocketDialer {
+	d.subprotocols = protocols
+	return d
+}
+
+// PingInterval sends a ping every interval and closes the connection when
+// no pong arrives within two intervals. Zero disables auto-ping.
+func (d *WebSocketDialer) PingInterval(interval time.Duration) *WebSocketDialer {
+	d.pingInterval = interval
+	return d
+}
+
+// ReadLimit sets the maximum size of a received message, 32MB by default.
+// Zero or a negative n restores the default, messages are always limited.
+func (d *WebSocketDialer) ReadLimit(n int64) *WebSocketDialer {
+	if n <= 0 {
+		n = defaultReadLimit
+	}
+	d.readLimit = n
+	return d
+}
+
+// FragmentSize splits written messages into frames of at most n bytes,
+// zero sends every message in a single frame.
+func (d *WebSocketDialer) FragmentSize(n int) *WebSocketDialer {
+	d.fragmentSize = n
+	return d
+}
+
+// Retry sets the initial and maximum reconnect delay used by Run, the delay
+// doubles after every failed attempt.
+func (d *WebSocketDialer) Retry(initial, max time.Duration) *WebSocketDialer {
+	d.retry, d.maxRetry = initial, max
+	return d
+}
+
+// MaxReconnects limits the number of consecutive reconnects made by Run,
+// zero means no limit.
+func (d *WebSocketDialer) MaxReconnects(n int) *WebSocketDialer {
+	d.maxReconnects = n
+	return d
+}
+
+// Dial performs the opening handshake.
+func (d *WebSocketDialer) Dial(ctx context.Context) (*WebSocketConn, *Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	u := d.c.resolveURL(d.url)
+	switch {
+	case strings.HasPrefix(u, "ws://"):
+		u = "http://" + u[len("ws://"):]
+	case strings.HasPrefix(u, "wss://"):
+		u = "https://" + u[len("wss://"):]
+	}
+	httpReq, err := http.NewRequest(GET.String(), u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBytes := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, keyBytes); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	handlers := make([]RequestHandler, 0, len(d.handlers)+2)
+	handlers = append(handlers, func(c *Client, req *Request) {
+		req.WithContext(ctx)
+	})
+	handlers = append(handlers, d.handlers...)
+	handlers = append(handlers, func(c *Client, req *Request) {
+		req.Method(GET)
+		req.Header("Connection", "Upgrade")
+		req.Header("Upgrade", "websocket")
+		req.Header("Sec-WebSocket-Version", "13")
+		req.Header("Sec-WebSocket-Key", key)
+		if len(d.subprotocols) > 0 {
+			req.Header("Sec-WebSocket-Protocol", strings.Join(d.subprotocols, ", "))
+		}
+	})
+	resp, err := d.c.do(d.c.handlerRequest(httpReq, handlers...), (*Client).websocketClient, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpResp := resp.Response()
+	if httpResp.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(httpResp.Header.Get("Upgrade"), "websocket") ||
+		!headerContainsToken(httpResp.Header, "Connection", "upgrade") ||
+		httpResp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		httpResp.Body.Close()
+		return nil, resp, fmt.Errorf("websocket: bad handshake: %s", httpResp.Status)
+	}
+	subprotocol := httpResp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !containsString(d.subprotocols, subprotocol) {
+		httpResp.Body.Close()
+		return nil, resp, fmt.Errorf("websocket: bad handshake: subprotocol %q was not requested", subprotocol)
+	}
+	rwc, ok := httpResp.Body.(io.ReadWriteCloser)
+	if !ok {
+		httpResp.Body.Close()
+		return nil, resp, errors.New("websocket: response body is not writable")
+	}
+
+	ws := &WebSocketConn{
+		rwc:          rwc,
+		br:           bufio.NewReader(rwc),
+		subprotocol:  subprotocol,
+		readLimit:    d.readLimit,
+		fragmentSize: d.fragmentSize,
+		closed:       make(chan struct{}),
+		lastPong:     time.Now(),
+	}
+	if d.pingInterval > 0 {
+		go ws.autoPing(d.pingInterval)
+	}
+	return ws, resp, nil
+}
+
+// Run dials and calls fn with the connection, reconnecting with backoff when
+// the dial or fn fails. It returns when fn returns nil or ctx is done.
+func (d *WebSocketDialer) Run(ctx context.Context, fn func(ws *WebSocketConn) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	delay := d.retry
+	reconnects := 0
+	for {
+		ws, _, err := d.Dial(ctx)
+		if err == nil {
+			stop := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					ws.Close()
+				case <-stop:
+				}
+			}()
+			err = fn(ws)
+			close(stop)
+			ws.Close()
+			if err == nil {
+				return nil
+			}
+			delay, reconnects = d.retry, 0
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		reconnects++
+		if d.maxReconnects > 0 && reconnects > d.maxReconnects {
+			return fmt.Errorf("websocket: giving up after %d reconnects: %w", d.maxReconnects, err)
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		if delay *= 2; delay > d.maxRetry {
+			delay = d.maxRetry
+		}
+	}
+}
+
+// websocketClient returns a copy of the http client restricted to HTTP/1.1,
+// which is required for the Upgrade handshake, that does not follow
+// redirects.
+func (c *Client) websocketClient() *http.Client {
+	hc := *c.c
+	if t := c.baseTransport(); t != nil {
+		t = t.Clone()
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if t.TLSClientConfig != nil {
+			t.TLSClientConfig.NextProtos = nil
+		}
+		hc.Transport = t
+	}
+	hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &hc
+}
+
+// WebSocketConn is a client WebSocket connection. One goroutine may read
+// while others write concurrently.
+type WebSocketConn struct {
+	rwc         io.ReadWriteCloser
+	br          *bufio.Reader
+	subprotocol string
+
+	readLimit    int64
+	fragmentSize int
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeSent bool
+
+	mu       sync.Mutex
+	lastPong time.Time
+	onPing   func(data []byte)
+	onPong   func(data []byte)
+}
+
+// Subprotocol returns the protocol selected by the server.
+func (ws *WebSocketConn) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// OnPing sets a callback for received pings, the pong is sent automatically.
+func (ws *WebSocketConn) OnPing(fn func(data []byte)) {
+	ws.mu.Lock()
+	ws.onPing = fn
+	ws.mu.Unlock()
+}
+
+// OnPong sets a callback for received pongs.
+func (ws *WebSocketConn) OnPong(fn func(data []byte)) {
+	ws.mu.Lock()
+	ws.onPong = fn
+	ws.mu.Unlock()
+}
+
+// ReadMessage returns the next text or binary message, reassembling
+// fragmented messages and answering control frames on the way. A close
+// frame from the peer is returned as *CloseError.
+func (ws *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			ws.closeConn()
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			ws.mu.Lock()
+			fn := ws.onPing
+			ws.mu.Unlock()
+			if fn != nil {
+				fn(payload)
+			}
+			if err := ws.writeFrame(true, PongMessage, payload); err != nil && !errors.Is(err, ErrWebSocketClosed) {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			ws.mu.Lock()
+			ws.lastPong = time.Now()
+			fn := ws.onPong
+			ws.mu.Unlock()
+			if fn != nil {
+				fn(payload)
+			}
+			continue
+		case CloseMessage:
+			ce := &CloseError{Code: CloseNoStatusReceived}
+			switch {
+			case len(payload) == 0:
+				// 1005 must not be sent, the reply has no status either
+				ws.writeCloseFrame(nil)
+			case len(payload) == 1:
+				return 0, nil, ws.fail(CloseProtocolError, "invalid close frame")
+			default:
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Text = string(payload[2:])
+				if !validCloseCode(ce.Code) {
+					return 0, nil, ws.fail(CloseProtocolError, fmt.Sprintf("invalid close code %d", ce.Code))
+				}
+				ws.writeClose(ce.Code, "")
+			}
+			ws.closeConn()
+			return 0, nil, ce
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "new message before the previous one finished")
+			}
+			messageType = opcode
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, ws.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		if int64(len(msg)+len(payload)) > ws.readLimit {
+			return 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, ws.fail(CloseInvalidPayload, "invalid utf-8 in text message")
+			}
+			return messageType, msg, nil
+		}
+	}
+}
+
+// WriteMessage sends a text, binary, ping or pong message.
+func (ws *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case PingMessage, PongMessage:
+		if len(data) > maxControlFrameLen {
+			return errors.New("websocket: control frame too long")
+		}
+		return ws.writeFrame(true, messageType, data)
+	case TextMessage, BinaryMessage:
+	default:
+		return fmt.Errorf("websocket: unsupported message type %d", messageType)
+	}
+
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	opcode := messageType
+	for {
+		n := len(data)
+		if ws.fragmentSize > 0 && n > ws.fragmentSize {
+			n = ws.fragmentSize
+		}
+		fin := n == len(data)
+		if err := ws.writeFrameLocked(fin, opcode, data[:n]); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		data, opcode = data[n:], continuationFrame
+	}
+}
+
+// WriteText sends a text message.
+func (ws *WebSocketConn) WriteText(s string) error {
+	return ws.WriteMessage(TextMessage, []byte(s))
+}
+
+// Ping sends a ping with the optional payload.
+func (ws *WebSocketConn) Ping(data []byte) error {
+	return ws.WriteMessage(PingMessage, data)
+}
+
+// Close sends a normal close frame and closes the connection.
+func (ws *WebSocketConn) Close() error {
+	return ws.CloseWithCode(CloseNormalClosure, "")
+}
+
+// CloseWithCode sends a close frame with the code and reason, then closes
+// the connection without waiting for the peer's reply.
+func (ws *WebSocketConn) CloseWithCode(code int, reason string) error {
+	err := ws.writeClose(code, reason)
+	if cerr := ws.closeConn(); err == nil || errors.Is(err, ErrWebSocketClosed) {
+		err = cerr
+	}
+	return err
+}
+
+func (ws *WebSocketConn) fail(code int, reason string) error {
+	ws.writeClose(code, reason)
+	ws.closeConn()
+	return &CloseError{Code: code, Text: reason}
+}
+
+func (ws *WebSocketConn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlFrameLen {
+		payload = payload[:maxControlFrameLen]
+	}
+	return ws.writeCloseFrame(payload)
+}
+
+// writeCloseFrame sends the close frame once, an empty payload carries no
+// status code.
+func (ws *WebSocketConn) writeCloseFrame(payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closeSent {
+		return nil
+	}
+	ws.closeSent = true
+	return ws.writeFrameLocked(true, CloseMessage, payload)
+}
+
+// validCloseCode reports whether code may be sent in a close frame, RFC 6455
+// section 7.4.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != CloseNoStatusReceived && code != CloseAbnormalClosure
+}
+
+func (ws *WebSocketConn) closeConn() error {
+	var err error
+	ws.closeOnce.Do(func() {
+		close(ws.closed)
+		err = ws.rwc.Close()
+	})
+	return err
+}
+
+func (ws *WebSocketConn) autoPing(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.closed:
+			return
+		case <-ticker.C:
+		}
+		ws.mu.Lock()
+		lastPong := ws.lastPong
+		ws.mu.Unlock()
+		if time.Since(lastPong) > 2*interval {
+			ws.closeConn()
+			return
+		}
+		if err := ws.writeFrame(true, PingMessage, nil); err != nil {
+			return
+		}
+	}
+}
+
+func (ws *WebSocketConn) writeFrame(fin bool, opcode int, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	return ws.writeFrameLocked(fin, opcode, payload)
+}
+
+// writeFrameLocked writes a single masked frame, ws.wmu must be held.
+func (ws *WebSocketConn) writeFrameLocked(fin bool, opcode int, payload []byte) error {
+	select {
+	case <-ws.closed:
+		return ErrWebSocketClosed
+	default:
+	}
+	if ws.closeSent && opcode != CloseMessage {
+		return ErrWebSocketClosed
+	}
+
+	header := make([]byte, 0, 14)
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	header = append(header, b0)
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, 0x80|byte(n))
+	case n <= 0xffff:
+		header = append(header, 0x80|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		header = append(header, 0x80|127)
+		header = append(header, ext[:]...)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(rand.Reader, mask[:]); err != nil {
+		return err
+	}
+	header = append(header, mask[:]...)
+
+	frame := make([]byte, len(header)+len(payload))
+	copy(frame, header)
+	masked := frame[len(header):]
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+	_, err := ws.rwc.Write(frame)
+	return err
+}
+
+func (ws *WebSocketConn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(ws.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(CloseProtocolError, "reserved bits set")
+	}
+	opcode = int(h[0] & 0x0f)
+	masked := h[1]&0x80 != 0
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= CloseMessage && (n > maxControlFrameLen || !fin) {
+		return false, 0, nil, ws.fail(CloseProtocolError, "invalid control frame")
+	}
+	if n > uint64(ws.readLimit) || n > math.MaxInt {
+		return false, 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package shttp_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smalls0098/pkg/shttp"
+)
+
+// echoServer answers every text message with the same text split into two
+// fragments, and closes the connection on a close frame.
+func echoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n")
+		rw.Flush()
+		for {
+			opcode, payload, err := readClientFrame(rw.Reader)
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case shttp.TextMessage:
+				half := len(payload) / 2
+				rw.Write(append([]byte{0x01, byte(half)}, payload[:half]...))
+				rw.Write([]byte{0x89, 0x00})
+				rw.Write(append([]byte{0x80, byte(len(payload) - half)}, payload[half:]...))
+			case shttp.CloseMessage:
+				rw.Write(append([]byte{0x88, byte(len(payload))}, payload...))
+				rw.Flush()
+				return
+			}
+			rw.Flush()
+		}
+	}))
+}
+
+func readClientFrame(r *bufio.Reader) (int, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, h[1]&0x7f)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return int(h[0] & 0x0f), payload, nil
+}
+
+func Test_WebSocket_Echo(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+
+	ws, _, err := shttp.New().WebSocket("ws" + strings.TrimPrefix(srv.URL, "http")).Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinged := false
+	ws.OnPing(func(data []byte) { pinged = true })
+	if err := ws.WriteText("hello websocket"); err != nil {
+		t.Fatal(err)
+	}
+	mt, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != shttp.TextMessage || string(data) != "hello websocket" || !pinged {
+		t.Fatalf("unexpected message %d %q, pinged %v", mt, data, pinged)
+	}
+
+	if err := ws.CloseWithCode(shttp.CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Fatal("expected error after close")
+	}
+	if err := ws.WriteText("late"); !errors.Is(err, shttp.ErrWebSocketClosed) {
+		t.Fatal(err)
+	}
+}
+
+// frameServer completes the handshake with the extra response headers,
+// writes frames and sends the payload of the first client frame to got.
+func frameServer(t *testing.T, headers string, frames []byte, got chan<- []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" + headers)
+		rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n")
+		rw.Write(frames)
+		rw.Flush()
+		_, payload, _ := readClientFrame(rw.Reader)
+		if got != nil {
+			got <- payload
+		}
+	}))
+}
+
+func Test_WebSocket_CloseWithoutStatus(t *testing.T) {
+	got := make(chan []byte, 1)
+	srv := frameServer(t, "", []byte{0x88, 0x00}, got)
+	defer srv.Close()
+
+	ws, _, err := shttp.New().WebSocket("ws" + strings.TrimPrefix(srv.URL, "http")).Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ce *shttp.CloseError
+	if _, _, err := ws.ReadMessage(); !errors.As(err, &ce) || ce.Code != shttp.CloseNoStatusReceived {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if payload := <-got; len(payload) != 0 {
+		t.Fatalf("close reply carries %v", payload)
+	}
+}
+
+func Test_WebSocket_FrameLength(t *testing.T) {
+	// a binary frame announcing 2^63 bytes
+	frame := []byte{0x82, 0x7f, 0x80, 0, 0, 0, 0, 0, 0, 0}
+	for _, limit := range []int64{0, -1} {
+		got := make(chan []byte, 1)
+		srv := frameServer(t, "", frame, got)
+		ws, _, err := shttp.New().WebSocket("ws" + strings.TrimPrefix(srv.URL, "http")).ReadLimit(limit).Dial(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ce *shttp.CloseError
+		if _, _, err := ws.ReadMessage(); !errors.As(err, &ce) || ce.Code != shttp.CloseMessageTooBig {
+			t.Fatalf("limit %d: unexpected error %v", limit, err)
+		}
+		if payload := <-got; len(payload) < 2 || int(payload[0])<<8|int(payload[1]) != shttp.CloseMessageTooBig {
+			t.Fatalf("limit %d: unexpected close reply %v", limit, payload)
+		}
+		srv.Close()
+	}
+}
+
+func Test_WebSocket_Subprotocol(t *testing.T) {
+	srv := frameServer(t, "Sec-WebSocket-Protocol: chat\r\n", nil, nil)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ws, _, err := shttp.New().WebSocket(url).Subprotocols("v2", "chat").Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Subprotocol() != "chat" {
+		t.Fatalf("unexpected subprotocol %q", ws.Subprotocol())
+	}
+	ws.Close()
+	if _, _, err := shttp.New().WebSocket(url).Subprotocols("v2").Dial(context.Background()); err == nil {
+		t.Fatal("unrequested subprotocol accepted")
+	}
+	if _, _, err := shttp.New().WebSocket(url).Dial(context.Background()); err == nil {
+		t.Fatal("subprotocol accepted without request")
+	}
+}